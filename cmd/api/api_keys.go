@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/recchia/greenlight/internal/data"
+	"github.com/recchia/greenlight/internal/validator"
+)
+
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.models.APIKeys.GetAllForUser(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string   `json:"name"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	owner, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	key := &data.APIKey{
+		Name:        input.Name,
+		Permissions: input.Permissions,
+	}
+
+	v := validator.New()
+
+	if data.ValidateAPIKey(v, key, owner); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	key, err = app.models.APIKeys.New(r.Context(), user.ID, key.Name, key.Permissions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := app.readAPIKeyParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := app.readAPIKeyParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name        *string  `json:"name"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		key.Name = *input.Name
+	}
+	if input.Permissions != nil {
+		key.Permissions = input.Permissions
+	}
+
+	owner, err := app.models.Permissions.GetAllForUser(r.Context(), key.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateAPIKey(v, key, owner); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.APIKeys.Update(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.APIKeys.Delete(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "API key successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readAPIKeyParam loads the current user's API key identified by the id path
+// parameter. Keys belonging to other users are reported as not found.
+func (app *application) readAPIKeyParam(w http.ResponseWriter, r *http.Request) (*data.APIKey, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	key, err := app.models.APIKeys.Get(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return key, true
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyHandlers(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		body     any
+		wantCode int
+	}{
+		{"List", http.MethodGet, "/v1/users/me/api-keys", nil, http.StatusOK},
+		{"Create", http.MethodPost, "/v1/users/me/api-keys", map[string]any{"name": "Importer", "permissions": []string{"movies:write"}}, http.StatusCreated},
+		{"Create without name", http.MethodPost, "/v1/users/me/api-keys", map[string]any{"permissions": []string{"movies:write"}}, http.StatusUnprocessableEntity},
+		{"Create with permission not held", http.MethodPost, "/v1/users/me/api-keys", map[string]any{"name": "Importer", "permissions": []string{"movies:delete"}}, http.StatusUnprocessableEntity},
+		{"Show", http.MethodGet, "/v1/users/me/api-keys/1", nil, http.StatusOK},
+		{"Show unknown", http.MethodGet, "/v1/users/me/api-keys/99", nil, http.StatusNotFound},
+		{"Update", http.MethodPatch, "/v1/users/me/api-keys/1", map[string]any{"name": "Nightly importer"}, http.StatusOK},
+		{"Delete", http.MethodDelete, "/v1/users/me/api-keys/1", nil, http.StatusOK},
+		{"Delete unknown", http.MethodDelete, "/v1/users/me/api-keys/99", nil, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.authJSON(t, tt.method, tt.urlPath, tt.body)
+
+			if code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, code)
+			}
+		})
+	}
+
+	t.Run("Plaintext key is only returned on creation", func(t *testing.T) {
+		_, _, body := ts.authJSON(t, http.MethodPost, "/v1/users/me/api-keys", map[string]any{"name": "Importer", "permissions": []string{"movies:read"}})
+		if !strings.Contains(body, `"key"`) {
+			t.Errorf("expected plaintext key in response, got %s", body)
+		}
+
+		_, _, body = ts.authJSON(t, http.MethodGet, "/v1/users/me/api-keys/1", nil)
+		if strings.Contains(body, `"key"`) {
+			t.Errorf("expected no plaintext key in response, got %s", body)
+		}
+	})
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		key      string
+		wantCode int
+	}{
+		{"Permitted", http.MethodGet, "/v1/movies/1", "apikey26charslong123456789", http.StatusOK},
+		{"Outside key permissions", http.MethodPost, "/v1/movies", "apikey26charslong123456789", http.StatusForbidden},
+		{"Unknown key", http.MethodGet, "/v1/movies/1", "unknownkey1234567890123456", http.StatusUnauthorized},
+		{"Malformed key", http.MethodGet, "/v1/movies/1", "short", http.StatusUnauthorized},
+		{"Managing keys", http.MethodGet, "/v1/users/me/api-keys", "apikey26charslong123456789", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.urlPath, bytes.NewReader([]byte("{}")))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Header.Set("Authorization", "ApiKey "+tt.key)
+
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs.Body.Close()
+
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, rs.StatusCode)
+			}
+		})
+	}
+}
+
+func TestAPIKeyRejectedOnAccountRoutes(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	routes := []struct {
+		method  string
+		urlPath string
+	}{
+		{http.MethodGet, "/v1/users/me"},
+		{http.MethodPatch, "/v1/users/me"},
+		{http.MethodDelete, "/v1/users/me"},
+		{http.MethodGet, "/v1/users/me/export"},
+		{http.MethodPost, "/v1/users/me/email"},
+		{http.MethodGet, "/v1/users/me/api-keys"},
+		{http.MethodPost, "/v1/users/me/api-keys"},
+		{http.MethodGet, "/v1/users/me/api-keys/1"},
+		{http.MethodPatch, "/v1/users/me/api-keys/1"},
+		{http.MethodDelete, "/v1/users/me/api-keys/1"},
+		{http.MethodPost, "/v1/users/me/2fa"},
+		{http.MethodPost, "/v1/users/me/2fa/confirm"},
+		{http.MethodDelete, "/v1/users/me/2fa"},
+		{http.MethodDelete, "/v1/tokens/authentication"},
+		{http.MethodDelete, "/v1/tokens/authentication/all"},
+	}
+
+	for _, tt := range routes {
+		t.Run(tt.method+" "+tt.urlPath, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.urlPath, bytes.NewReader([]byte("{}")))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Header.Set("Authorization", "ApiKey apikey26charslong123456789")
+
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+
+			body, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rs.StatusCode != http.StatusForbidden {
+				t.Errorf("expected status code %d, got %d", http.StatusForbidden, rs.StatusCode)
+			}
+			if !strings.Contains(string(body), "API key") {
+				t.Errorf("expected API key error, got %s", body)
+			}
+		})
+	}
+}
@@ -9,9 +9,17 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey        = contextKey("user")
+	permissionsContextKey = contextKey("permissions")
+	apiKeyContextKey      = contextKey("apiKey")
+	requestInfoContextKey = contextKey("requestInfo")
+	requestIDContextKey   = contextKey("requestID")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	app.contextGetRequestInfo(r).userID = user.ID
+
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 
 	return r.WithContext(ctx)
@@ -25,3 +33,70 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+
+	return permissions, ok
+}
+
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was authenticated with,
+// if any.
+func (app *application) contextGetAPIKey(r *http.Request) (*data.APIKey, bool) {
+	key, ok := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+
+	return key, ok
+}
+
+// requestInfo collects details about a request as it passes through the
+// middleware chain and router. It is added to the context by the outermost
+// middleware and filled in further down, so that it can be read back once the
+// request has been served.
+type requestInfo struct {
+	route  string
+	userID int64
+}
+
+func (app *application) contextSetRequestInfo(r *http.Request) (*http.Request, *requestInfo) {
+	info := &requestInfo{}
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+
+	return r.WithContext(ctx), info
+}
+
+func (app *application) contextGetRequestInfo(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		return info
+	}
+
+	// Requests that didn't pass through logRequest, as in some tests, get a
+	// throwaway value so callers don't need to check.
+	return &requestInfo{}
+}
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+
+	return r.WithContext(ctx)
+}
+
+// requestIDFromContext returns the ID assigned to the request ctx belongs to,
+// if any. It takes a context rather than a request so that the log handler
+// and background tasks can use it too.
+func requestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDContextKey).(string)
+
+	return id, ok
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -11,7 +14,7 @@ func (app *application) logError(r *http.Request, err error) {
 		uri    = r.URL.RequestURI()
 	)
 
-	app.logger.Error(err.Error(), "method", method, "uri", uri)
+	app.logger.ErrorContext(r.Context(), err.Error(), "method", method, "uri", uri)
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
@@ -61,6 +64,12 @@ func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
+func (app *application) loginLockedResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -72,6 +81,25 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "ApiKey")
+	message := "invalid or revoked API key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// externalLoginFailedResponse logs why a login through the identity provider
+// failed but doesn't reveal it to the client.
+func (app *application) externalLoginFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.WarnContext(r.Context(), "external login failed", "error", err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+	message := "external login failed"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidRefreshTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or expired refresh token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -81,6 +109,11 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed with an API key"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"sync"
 )
 
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
@@ -15,7 +17,70 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 
 	err := app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
-		app.logger.Error(err.Error())
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// livenessHandler reports that the process is up and serving requests. It
+// deliberately checks no dependencies, so an outage of one of them never gets
+// the server restarted.
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readinessHandler reports whether the server should receive traffic: it is
+// not shutting down and every dependency in app.readyChecks responds within
+// the readiness timeout.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if app.shuttingDown.Load() {
+		err := app.writeJSON(w, http.StatusServiceUnavailable, envelope{"status": "shutting down"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.readiness.timeout)
+	defer cancel()
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		ready  = true
+		checks = make(map[string]string, len(app.readyChecks))
+	)
+
+	for name, check := range app.readyChecks {
+		wg.Go(func() {
+			err := check(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				app.logger.WarnContext(r.Context(), "readiness check failed", "check", name, "error", err.Error())
+				checks[name] = "unavailable"
+				ready = false
+				return
+			}
+
+			checks[name] = "ok"
+		})
+	}
+
+	wg.Wait()
+
+	status, env := http.StatusOK, envelope{"status": "ready", "checks": checks}
+	if !ready {
+		status, env = http.StatusServiceUnavailable, envelope{"status": "unavailable", "checks": checks}
+	}
+
+	err := app.writeJSON(w, status, env, nil)
+	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"maps"
 	"net/http"
 	"testing"
+	"time"
 )
 
 func TestHealthcheckHandler(t *testing.T) {
@@ -34,3 +38,146 @@ func TestHealthcheckHandler(t *testing.T) {
 		t.Errorf("expected status 'available', got %q", response.Status)
 	}
 }
+
+func TestLivenessHandler(t *testing.T) {
+	app := newTestApplication(t)
+	app.readyChecks = map[string]func(context.Context) error{
+		"database": func(ctx context.Context) error { return errors.New("connection refused") },
+	}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/livez")
+
+	if code != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+	}
+	var response struct {
+		Status string `json:"status"`
+	}
+
+	err := json.Unmarshal([]byte(body), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Status != "alive" {
+		t.Errorf("expected status 'alive', got %q", response.Status)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		checks       map[string]func(context.Context) error
+		shuttingDown bool
+		wantCode     int
+		wantStatus   string
+		wantChecks   map[string]string
+	}{
+		{
+			name: "Dependencies available",
+			checks: map[string]func(context.Context) error{
+				"database": func(ctx context.Context) error { return nil },
+				"smtp":     func(ctx context.Context) error { return nil },
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "ready",
+			wantChecks: map[string]string{"database": "ok", "smtp": "ok"},
+		},
+		{
+			name: "Dependency unavailable",
+			checks: map[string]func(context.Context) error{
+				"database": func(ctx context.Context) error { return errors.New("connection refused") },
+				"smtp":     func(ctx context.Context) error { return nil },
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "unavailable",
+			wantChecks: map[string]string{"database": "unavailable", "smtp": "ok"},
+		},
+		{
+			name: "Dependency times out",
+			checks: map[string]func(context.Context) error{
+				"database": func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "unavailable",
+			wantChecks: map[string]string{"database": "unavailable"},
+		},
+		{
+			name: "Shutting down",
+			checks: map[string]func(context.Context) error{
+				"database": func(ctx context.Context) error { return nil },
+			},
+			shuttingDown: true,
+			wantCode:     http.StatusServiceUnavailable,
+			wantStatus:   "shutting down",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.readiness.timeout = 50 * time.Millisecond
+			app.readyChecks = tt.checks
+			app.shuttingDown.Store(tt.shuttingDown)
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			code, _, body := ts.get(t, "/readyz")
+
+			if code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, code)
+			}
+
+			var response struct {
+				Status string            `json:"status"`
+				Checks map[string]string `json:"checks"`
+			}
+
+			err := json.Unmarshal([]byte(body), &response)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if response.Status != tt.wantStatus {
+				t.Errorf("expected status %q, got %q", tt.wantStatus, response.Status)
+			}
+			if !maps.Equal(response.Checks, tt.wantChecks) {
+				t.Errorf("expected checks %v, got %v", tt.wantChecks, response.Checks)
+			}
+		})
+	}
+}
+
+func TestProbesBypassRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 1
+	app.config.limiter.burst = 1
+	app.readyChecks = map[string]func(context.Context) error{
+		"database": func(ctx context.Context) error { return nil },
+	}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.get(t, "/v1/healthcheck")
+
+	code, _, _ := ts.get(t, "/v1/healthcheck")
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("expected status code %d, got %d", http.StatusTooManyRequests, code)
+	}
+
+	for _, urlPath := range []string{"/livez", "/readyz"} {
+		code, _, _ := ts.get(t, urlPath)
+		if code != http.StatusOK {
+			t.Errorf("expected status code %d for %s, got %d", http.StatusOK, urlPath, code)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,13 +12,11 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/julienschmidt/httprouter"
 	"github.com/recchia/greenlight/internal/validator"
 )
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
-	params := httprouter.ParamsFromContext(r.Context())
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	if err != nil || id < 1 {
 		return 0, errors.New("invalid id parameter")
 	}
@@ -25,6 +24,26 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+func (app *application) readBearerToken(r *http.Request) (string, error) {
+	scheme, credentials, err := app.readAuthorization(r)
+	if err != nil || scheme != "Bearer" {
+		return "", errors.New("invalid authorization header")
+	}
+
+	return credentials, nil
+}
+
+// readAuthorization splits the Authorization header into its scheme and
+// credentials.
+func (app *application) readAuthorization(r *http.Request) (string, string, error) {
+	headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(headerParts) != 2 {
+		return "", "", errors.New("invalid authorization header")
+	}
+
+	return headerParts[0], headerParts[1], nil
+}
+
 type envelope map[string]any
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
@@ -51,37 +70,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, target
 	err := decoder.Decode(target)
 
 	if err != nil {
-		var (
-			syntaxError           *json.SyntaxError
-			unmarshalTypeError    *json.UnmarshalTypeError
-			invalidUnmarshalError *json.InvalidUnmarshalError
-			maxBytesError         *http.MaxBytesError
-		)
-
-		switch true {
-		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
-		case errors.As(err, &unmarshalTypeError):
-			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
-			}
-
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
-		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
-		case strings.HasPrefix(err.Error(), "json: unknown field "):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-
-			return fmt.Errorf("body contains unknown key %q", fieldName)
-		case errors.As(err, &maxBytesError):
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
-		case errors.As(err, &invalidUnmarshalError):
-			panic(err)
-		default:
-			return err
-		}
+		return jsonDecodeError(err)
 	}
 
 	err = decoder.Decode(&struct{}{})
@@ -93,6 +82,42 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, target
 	return nil
 }
 
+// jsonDecodeError turns an error from decoding a request body into one that
+// can be shown to the client.
+func jsonDecodeError(err error) error {
+	var (
+		syntaxError           *json.SyntaxError
+		unmarshalTypeError    *json.UnmarshalTypeError
+		invalidUnmarshalError *json.InvalidUnmarshalError
+		maxBytesError         *http.MaxBytesError
+	)
+
+	switch true {
+	case errors.As(err, &syntaxError):
+		return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("body contains badly-formed JSON")
+	case errors.As(err, &unmarshalTypeError):
+		if unmarshalTypeError.Field != "" {
+			return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+		}
+
+		return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+	case errors.Is(err, io.EOF):
+		return errors.New("body must not be empty")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+
+		return fmt.Errorf("body contains unknown key %q", fieldName)
+	case errors.As(err, &maxBytesError):
+		return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+	case errors.As(err, &invalidUnmarshalError):
+		panic(err)
+	default:
+		return err
+	}
+}
+
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
 	s := qs.Get(key)
 	if s == "" {
@@ -126,16 +151,20 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
-func (app *application) background(fn func()) {
+// background runs fn in a goroutine that shutdown waits for. fn is given a
+// context that carries the caller's trace but isn't cancelled along with it,
+// since background tasks usually outlive the request that started them.
+func (app *application) background(ctx context.Context, fn func(ctx context.Context)) {
+	ctx = context.WithoutCancel(ctx)
 
 	app.wg.Go(func() {
 
 		defer func() {
 			if err := recover(); err != nil {
-				app.logger.Error(fmt.Sprintf("panic occurred in background task: %v", err))
+				app.logger.ErrorContext(ctx, fmt.Sprintf("panic occurred in background task: %v", err))
 			}
 		}()
 
-		fn()
+		fn(ctx)
 	})
 }
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// purgeDeletedUsers permanently removes soft deleted users once their grace
+// period has passed. It runs until ctx is cancelled.
+func (app *application) purgeDeletedUsers(ctx context.Context) {
+	ticker := time.NewTicker(app.config.users.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := app.models.Users.DeleteExpired(ctx, time.Now().Add(-app.config.users.deletionGracePeriod))
+			if err != nil {
+				app.logger.ErrorContext(ctx, "failed to purge deleted users", "error", err.Error())
+				continue
+			}
+
+			if deleted > 0 {
+				app.logger.InfoContext(ctx, "purged deleted users", "count", deleted)
+			}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/recchia/greenlight/internal/data"
+)
+
+const (
+	authModeToken = "token"
+	authModeJWT   = "jwt"
+)
+
+type jwtKey struct {
+	id        string
+	signKey   any
+	verifyKey any
+}
+
+// jwtKeySet holds the keys used to sign and verify JWTs. The first key is
+// the active signing key; the remaining keys are only used for verification
+// so that tokens signed before a rotation stay valid until they expire.
+type jwtKeySet struct {
+	method jwt.SigningMethod
+	keys   []jwtKey
+}
+
+// parseJWTKeys parses a space separated list of "kid:base64key" pairs. For
+// HS256 the key is the shared secret, for EdDSA it is the 32 byte Ed25519
+// private key seed.
+func parseJWTKeys(algorithm, s string) (*jwtKeySet, error) {
+	ks := &jwtKeySet{}
+
+	switch algorithm {
+	case "HS256":
+		ks.method = jwt.SigningMethodHS256
+	case "EdDSA":
+		ks.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported jwt algorithm %q", algorithm)
+	}
+
+	for field := range strings.FieldsSeq(s) {
+		id, encoded, found := strings.Cut(field, ":")
+		if !found || id == "" {
+			return nil, fmt.Errorf("jwt key %q must be in the form kid:base64key", field)
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", id, err)
+		}
+
+		key := jwtKey{id: id}
+
+		switch ks.method {
+		case jwt.SigningMethodHS256:
+			if len(raw) < 32 {
+				return nil, fmt.Errorf("jwt key %q must be at least 32 bytes long", id)
+			}
+			key.signKey = raw
+			key.verifyKey = raw
+		case jwt.SigningMethodEdDSA:
+			if len(raw) != ed25519.SeedSize {
+				return nil, fmt.Errorf("jwt key %q must be a %d byte ed25519 seed", id, ed25519.SeedSize)
+			}
+			privateKey := ed25519.NewKeyFromSeed(raw)
+			key.signKey = privateKey
+			key.verifyKey = privateKey.Public()
+		}
+
+		ks.keys = append(ks.keys, key)
+	}
+
+	if len(ks.keys) == 0 {
+		return nil, errors.New("at least one jwt key must be provided")
+	}
+
+	return ks, nil
+}
+
+func (ks *jwtKeySet) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	for _, key := range ks.keys {
+		if key.id == kid {
+			return key.verifyKey, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown jwt key id %q", kid)
+}
+
+// authClaims are the claims carried by an access JWT. TokenVersion must match
+// the user's current token version, which is incremented to revoke every JWT
+// issued to them before.
+type authClaims struct {
+	Activated    bool             `json:"activated"`
+	Permissions  data.Permissions `json:"permissions"`
+	TokenVersion int              `json:"token_version"`
+	jwt.RegisteredClaims
+}
+
+func (app *application) newJWT(user *data.User, permissions data.Permissions, tokenVersion int, ttl time.Duration) (*data.Token, error) {
+	now := time.Now()
+	key := app.jwtKeys.keys[0]
+
+	claims := authClaims{
+		Activated:    user.Activated,
+		Permissions:  permissions,
+		TokenVersion: tokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatInt(user.ID, 10),
+			Issuer:    app.config.jwt.issuer,
+			Audience:  jwt.ClaimStrings{app.config.jwt.issuer},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+
+	token := jwt.NewWithClaims(app.jwtKeys.method, claims)
+	token.Header["kid"] = key.id
+
+	signed, err := token.SignedString(key.signKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{
+		Plaintext: signed,
+		UserID:    user.ID,
+		Expiry:    claims.ExpiresAt.Time,
+		Scope:     data.ScopeAuthentication,
+	}, nil
+}
+
+// parseJWT verifies an access JWT and returns the user, permissions and token
+// version it was issued with. The token version still has to be checked
+// against the user's current one.
+func (app *application) parseJWT(tokenString string) (*data.User, data.Permissions, int, error) {
+	var claims authClaims
+
+	_, err := jwt.ParseWithClaims(tokenString, &claims, app.jwtKeys.keyFunc,
+		jwt.WithValidMethods([]string{app.jwtKeys.method.Alg()}),
+		jwt.WithIssuer(app.config.jwt.issuer),
+		jwt.WithAudience(app.config.jwt.issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || userID < 1 {
+		return nil, nil, 0, errors.New("invalid jwt subject")
+	}
+
+	user := &data.User{
+		ID:        userID,
+		Activated: claims.Activated,
+	}
+
+	return user, claims.Permissions, claims.TokenVersion, nil
+}
+
+// isJWT reports whether a bearer token looks like a compact JWS rather than
+// an opaque token from the tokens table.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	keys := []map[string]string{}
+
+	// Symmetric HS256 secrets must never be published, so the set is only
+	// populated when signing with EdDSA.
+	if app.jwtKeys != nil && app.jwtKeys.method == jwt.SigningMethodEdDSA {
+		for _, key := range app.jwtKeys.keys {
+			keys = append(keys, map[string]string{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"use": "sig",
+				"alg": "EdDSA",
+				"kid": key.id,
+				"x":   base64.RawURLEncoding.EncodeToString(key.verifyKey.(ed25519.PublicKey)),
+			})
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
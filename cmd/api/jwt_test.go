@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/recchia/greenlight/internal/data"
+)
+
+func newTestJWTApplication(t *testing.T, algorithm, keys string) *application {
+	app := newTestApplication(t)
+	app.config.auth.mode = authModeJWT
+	app.config.jwt.algorithm = algorithm
+	app.config.jwt.issuer = "greenlight-test"
+
+	ks, err := parseJWTKeys(algorithm, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.jwtKeys = ks
+
+	return app
+}
+
+func testJWTKey(id string, size int) string {
+	return id + ":" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat(id[:1], size)))
+}
+
+func TestParseJWTKeys(t *testing.T) {
+	t.Run("Valid HS256", func(t *testing.T) {
+		ks, err := parseJWTKeys("HS256", testJWTKey("a", 32)+" "+testJWTKey("b", 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ks.keys) != 2 || ks.keys[0].id != "a" {
+			t.Errorf("expected two keys with 'a' as the signing key, got %+v", ks.keys)
+		}
+	})
+
+	t.Run("Short HS256 secret", func(t *testing.T) {
+		_, err := parseJWTKeys("HS256", testJWTKey("a", 16))
+		if err == nil {
+			t.Error("expected error for short secret")
+		}
+	})
+
+	t.Run("Invalid EdDSA seed", func(t *testing.T) {
+		_, err := parseJWTKeys("EdDSA", testJWTKey("a", 64))
+		if err == nil {
+			t.Error("expected error for invalid seed length")
+		}
+	})
+
+	t.Run("Unsupported algorithm", func(t *testing.T) {
+		_, err := parseJWTKeys("RS256", testJWTKey("a", 32))
+		if err == nil {
+			t.Error("expected error for unsupported algorithm")
+		}
+	})
+}
+
+func TestJWTRoundTrip(t *testing.T) {
+	user := &data.User{ID: 42, Activated: true}
+	permissions := data.Permissions{"movies:read"}
+
+	for _, algorithm := range []string{"HS256", "EdDSA"} {
+		t.Run(algorithm, func(t *testing.T) {
+			app := newTestJWTApplication(t, algorithm, testJWTKey("a", 32))
+
+			token, err := app.newJWT(user, permissions, 1, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, gotPermissions, gotVersion, err := app.parseJWT(token.Plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.ID != 42 || !got.Activated {
+				t.Errorf("unexpected user %+v", got)
+			}
+			if gotVersion != 1 {
+				t.Errorf("expected token version 1, got %d", gotVersion)
+			}
+			if !gotPermissions.Has("movies:read") {
+				t.Errorf("expected movies:read permission, got %v", gotPermissions)
+			}
+		})
+	}
+
+	t.Run("Rotated key", func(t *testing.T) {
+		old := newTestJWTApplication(t, "HS256", testJWTKey("a", 32))
+
+		token, err := old.newJWT(user, permissions, 1, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rotated := newTestJWTApplication(t, "HS256", testJWTKey("b", 32)+" "+testJWTKey("a", 32))
+		if _, _, _, err := rotated.parseJWT(token.Plaintext); err != nil {
+			t.Errorf("expected token signed with retired key to verify: %v", err)
+		}
+
+		removed := newTestJWTApplication(t, "HS256", testJWTKey("b", 32))
+		if _, _, _, err := removed.parseJWT(token.Plaintext); err == nil {
+			t.Error("expected token signed with removed key to be rejected")
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		app := newTestJWTApplication(t, "HS256", testJWTKey("a", 32))
+
+		token, err := app.newJWT(user, permissions, 1, -time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, _, err := app.parseJWT(token.Plaintext); err == nil {
+			t.Error("expected expired token to be rejected")
+		}
+	})
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	app := newTestJWTApplication(t, "EdDSA", testJWTKey("a", 32))
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		userID       int64
+		tokenVersion int
+		wantCode     int
+	}{
+		{"Current token version", 1, data.MockTokenVersion, http.StatusOK},
+		{"Revoked token version", 1, data.MockTokenVersion - 1, http.StatusUnauthorized},
+		{"Deleted user", 99, data.MockTokenVersion, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := app.newJWT(&data.User{ID: tt.userID, Activated: true}, data.Permissions{"movies:read"}, tt.tokenVersion, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/movies/1", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token.Plaintext)
+
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs.Body.Close()
+
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, rs.StatusCode)
+			}
+		})
+	}
+
+	code, _, body := ts.get(t, "/.well-known/jwks.json")
+	if code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, code)
+	}
+
+	var response struct {
+		Keys []map[string]string `json:"keys"`
+	}
+
+	err := json.Unmarshal([]byte(body), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response.Keys) != 1 || response.Keys[0]["kid"] != "a" {
+		t.Errorf("expected a single published key with kid 'a', got %v", response.Keys)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/recchia/greenlight/internal/data"
+	"github.com/tomasen/realip"
+)
+
+func (app *application) loginAttemptKeys(r *http.Request, email string) (string, string) {
+	return "email:" + strings.ToLower(email), "ip:" + realip.FromRequest(r)
+}
+
+func (app *application) emailLockoutPolicy() data.LockoutPolicy {
+	return data.LockoutPolicy{
+		Threshold: app.config.login.emailThreshold,
+		BaseDelay: app.config.login.baseDelay,
+		MaxDelay:  app.config.login.maxDelay,
+		Window:    app.config.login.window,
+	}
+}
+
+func (app *application) ipLockoutPolicy() data.LockoutPolicy {
+	policy := app.emailLockoutPolicy()
+	policy.Threshold = app.config.login.ipThreshold
+
+	return policy
+}
+
+// loginLockout returns how long the caller must wait before trying to log in
+// again, or zero if none of the keys are locked.
+func (app *application) loginLockout(ctx context.Context, keys ...string) (time.Duration, error) {
+	var retryAfter time.Duration
+
+	for _, key := range keys {
+		attempt, err := app.models.LoginAttempts.Get(ctx, key)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				continue
+			}
+			return 0, err
+		}
+
+		if attempt.IsLocked() {
+			retryAfter = max(retryAfter, attempt.RetryAfter())
+		}
+	}
+
+	return retryAfter, nil
+}
+
+// recordLoginFailure counts a failed login against both the email address
+// and the client IP. The account owner, if there is one, is notified the
+// first time the email address gets locked within the failure window.
+func (app *application) recordLoginFailure(ctx context.Context, emailKey, ipKey string, user *data.User) error {
+	policy := app.emailLockoutPolicy()
+
+	attempt, err := app.models.LoginAttempts.RecordFailure(ctx, emailKey, policy)
+	if err != nil {
+		return err
+	}
+
+	_, err = app.models.LoginAttempts.RecordFailure(ctx, ipKey, app.ipLockoutPolicy())
+	if err != nil {
+		return err
+	}
+
+	if user != nil && attempt.Failures == policy.Threshold {
+		app.background(ctx, func(ctx context.Context) {
+			templateData := map[string]any{
+				"lockedUntil": attempt.LockedUntil.UTC().Format(time.RFC1123),
+			}
+
+			err := app.mailer.Send(ctx, user.Email, "account_locked.html", templateData)
+			if err != nil {
+				app.logger.ErrorContext(ctx, fmt.Sprintf("failed to send account locked email to %s: %v", user.Email, err))
+			}
+		})
+	}
+
+	return nil
+}
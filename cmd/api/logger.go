@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// contextHandler is a slog.Handler that adds the ID of the request carried by
+// the context to each record. Records have to be logged with one of the
+// Logger's *Context methods for the ID to be found.
+type contextHandler struct {
+	slog.Handler
+}
+
+func newContextHandler(h slog.Handler) *contextHandler {
+	return &contextHandler{h}
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id, ok := requestIDFromContext(ctx); ok {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"expvar"
 	"flag"
@@ -12,13 +13,18 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/recchia/greenlight/internal/data"
 	"github.com/recchia/greenlight/internal/mailer"
 	"github.com/recchia/greenlight/internal/vcs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 var (
@@ -33,6 +39,7 @@ type config struct {
 		maxOpenConnections int
 		maxIdleConnections int
 		maxIdleTime        time.Duration
+		queryTimeout       time.Duration
 	}
 	limiter struct {
 		rps     float64
@@ -49,20 +56,77 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	auth struct {
+		mode string
+	}
+	jwt struct {
+		algorithm string
+		keys      string
+		issuer    string
+	}
+	tokens struct {
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+	}
+	cache struct {
+		enabled bool
+		ttl     time.Duration
+		size    int
+	}
+	users struct {
+		deletionGracePeriod time.Duration
+		purgeInterval       time.Duration
+	}
+	trace struct {
+		exporter    string
+		sampleRatio float64
+	}
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		redirectURL  string
+	}
+	twoFactor struct {
+		issuer       string
+		challengeTTL time.Duration
+	}
+	cursor struct {
+		key []byte
+	}
+	suggest struct {
+		timeout time.Duration
+	}
+	readiness struct {
+		checkSMTP  bool
+		timeout    time.Duration
+		drainDelay time.Duration
+	}
+	login struct {
+		emailThreshold int
+		ipThreshold    int
+		baseDelay      time.Duration
+		maxDelay       time.Duration
+		window         time.Duration
+	}
 }
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	mailer *mailer.Mailer
-	wg     sync.WaitGroup
+	config       config
+	logger       *slog.Logger
+	models       data.Models
+	mailer       *mailer.Mailer
+	jwtKeys      *jwtKeySet
+	oidc         *oidcProvider
+	readyChecks  map[string]func(context.Context) error
+	shuttingDown atomic.Bool
+	wg           sync.WaitGroup
 }
 
 func main() {
 	var cfg config
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	logger := slog.New(newContextHandler(slog.NewTextHandler(os.Stdout, nil)))
 
 	err := godotenv.Load()
 	if err != nil {
@@ -78,6 +142,7 @@ func main() {
 	flag.IntVar(&cfg.db.maxOpenConnections, "max-open-connections", 25, "Maximum number of open connections to the database")
 	flag.IntVar(&cfg.db.maxIdleConnections, "max-idle-connections", 25, "Maximum number of idle connections in the pool")
 	flag.DurationVar(&cfg.db.maxIdleTime, "max-idle-time", 15*time.Minute, "Maximum duration that a connection can be idle before being closed")
+	flag.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", 3*time.Second, "Maximum duration of a single database query")
 
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Requests per second limit for the rate limiter")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Burst size for the rate limiter")
@@ -96,6 +161,52 @@ func main() {
 		return nil
 	})
 
+	flag.StringVar(&cfg.auth.mode, "auth-mode", authModeToken, "Authentication token mode (token|jwt)")
+
+	flag.StringVar(&cfg.jwt.algorithm, "jwt-algorithm", "HS256", "JWT signing algorithm (HS256|EdDSA)")
+	flag.StringVar(&cfg.jwt.keys, "jwt-keys", os.Getenv("GREENLIGHT_JWT_KEYS"), "JWT keys as space separated kid:base64key pairs, the first one is used for signing")
+	flag.StringVar(&cfg.jwt.issuer, "jwt-issuer", "greenlight", "JWT issuer and audience")
+
+	flag.DurationVar(&cfg.tokens.accessTTL, "access-token-ttl", 15*time.Minute, "Lifetime of authentication tokens")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "refresh-token-ttl", 30*24*time.Hour, "Lifetime of refresh tokens")
+
+	flag.BoolVar(&cfg.cache.enabled, "cache-enabled", true, "Cache user and permission lookups in memory")
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", 30*time.Second, "Maximum time a cached lookup is served before it is refreshed")
+	flag.IntVar(&cfg.cache.size, "cache-size", 10_000, "Maximum number of entries in each cache")
+
+	flag.DurationVar(&cfg.users.deletionGracePeriod, "user-deletion-grace-period", 30*24*time.Hour, "Time a deleted account is kept before it is permanently removed")
+	flag.DurationVar(&cfg.users.purgeInterval, "user-purge-interval", time.Hour, "How often permanently removable accounts are purged")
+
+	flag.StringVar(&cfg.trace.exporter, "trace-exporter", traceExporterNone, "Trace exporter (none|stdout|otlp)")
+	flag.Float64Var(&cfg.trace.sampleRatio, "trace-sample-ratio", 1, "Fraction of new traces to sample")
+
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "OpenID Connect issuer URL, enables login through the identity provider")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", os.Getenv("GREENLIGHT_OIDC_CLIENT_SECRET"), "OpenID Connect client secret")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "", "URL the identity provider redirects back to, routed to /v1/oidc/callback")
+
+	flag.StringVar(&cfg.twoFactor.issuer, "totp-issuer", "Greenlight", "Issuer shown by authenticator apps")
+	flag.DurationVar(&cfg.twoFactor.challengeTTL, "two-factor-challenge-ttl", 5*time.Minute, "Time allowed to complete a two-factor login")
+
+	flag.IntVar(&cfg.login.emailThreshold, "login-email-threshold", 5, "Failed logins for an email address before it is locked out")
+	flag.IntVar(&cfg.login.ipThreshold, "login-ip-threshold", 20, "Failed logins from an IP address before it is locked out")
+	flag.DurationVar(&cfg.login.baseDelay, "login-lockout-base", time.Minute, "Initial lockout, doubled on every further failure")
+	flag.DurationVar(&cfg.login.maxDelay, "login-lockout-max", time.Hour, "Maximum lockout")
+	flag.DurationVar(&cfg.login.window, "login-failure-window", time.Hour, "Time after which failed logins are forgotten")
+
+	cfg.cursor.key = []byte(os.Getenv("GREENLIGHT_CURSOR_SECRET"))
+	flag.Func("cursor-secret", "Secret used to sign pagination cursors, shared by all instances; required outside development", func(s string) error {
+		cfg.cursor.key = []byte(s)
+
+		return nil
+	})
+
+	flag.DurationVar(&cfg.suggest.timeout, "suggest-timeout", 250*time.Millisecond, "Latency budget for title suggestions, after which none are returned")
+
+	flag.BoolVar(&cfg.readiness.checkSMTP, "readyz-smtp", false, "Report not ready while the SMTP server is unreachable")
+	flag.DurationVar(&cfg.readiness.timeout, "readyz-timeout", 2*time.Second, "Maximum duration of the readiness checks")
+	flag.DurationVar(&cfg.readiness.drainDelay, "shutdown-drain-delay", 5*time.Second, "Time /readyz reports not ready before the listener is closed on shutdown, so that load balancers stop sending traffic (0 to disable)")
+
 	displayVersion := flag.Bool("version", false, "Display version")
 
 	flag.Parse()
@@ -105,6 +216,95 @@ func main() {
 		os.Exit(0)
 	}
 
+	if cfg.db.queryTimeout <= 0 {
+		logger.Error("-db-query-timeout must be greater than zero")
+		os.Exit(1)
+	}
+
+	if cfg.cache.ttl <= 0 {
+		logger.Error("-cache-ttl must be greater than zero")
+		os.Exit(1)
+	}
+
+	if cfg.cache.size <= 0 {
+		logger.Error("-cache-size must be greater than zero")
+		os.Exit(1)
+	}
+
+	if cfg.users.deletionGracePeriod <= 0 {
+		logger.Error("-user-deletion-grace-period must be greater than zero")
+		os.Exit(1)
+	}
+
+	if cfg.users.purgeInterval <= 0 {
+		logger.Error("-user-purge-interval must be greater than zero")
+		os.Exit(1)
+	}
+
+	// A random key only suits a single development process: cursors signed
+	// with it are rejected by other instances and after a restart.
+	if len(cfg.cursor.key) == 0 {
+		if cfg.env != "development" {
+			logger.Error("-cursor-secret or GREENLIGHT_CURSOR_SECRET must be set outside development")
+			os.Exit(1)
+		}
+
+		logger.Warn("no cursor secret set, signing pagination cursors with a random key")
+
+		cfg.cursor.key = make([]byte, 32)
+		rand.Read(cfg.cursor.key)
+	}
+
+	var jwtKeys *jwtKeySet
+
+	switch cfg.auth.mode {
+	case authModeToken:
+	case authModeJWT:
+		jwtKeys, err = parseJWTKeys(cfg.jwt.algorithm, cfg.jwt.keys)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	default:
+		logger.Error(fmt.Sprintf("unsupported auth mode %q", cfg.auth.mode))
+		os.Exit(1)
+	}
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	if cfg.trace.exporter != traceExporterNone {
+		tracerProvider, err := newTracerProvider(context.Background(), cfg.trace.exporter, cfg.trace.sampleRatio)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		otel.SetTracerProvider(tracerProvider)
+
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err := tracerProvider.Shutdown(ctx)
+			if err != nil {
+				logger.Error(err.Error())
+			}
+		}()
+	}
+
+	var identityProvider *oidcProvider
+
+	if cfg.oidc.issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		identityProvider, err = newOIDCProvider(ctx, cfg.oidc.issuer, cfg.oidc.clientID, cfg.oidc.clientSecret, cfg.oidc.redirectURL)
+		cancel()
+
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.Error(err.Error())
@@ -133,11 +333,28 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "greenlight"))
+
+	models := data.NewModels(db, cfg.db.queryTimeout)
+	if cfg.cache.enabled {
+		models = data.NewCachedModels(models, cfg.cache.ttl, cfg.cache.size)
+	}
+
+	readyChecks := map[string]func(context.Context) error{
+		"database": db.PingContext,
+	}
+	if cfg.readiness.checkSMTP {
+		readyChecks["smtp"] = smtpMailer.Ping
+	}
+
 	app := application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: smtpMailer,
+		config:      cfg,
+		logger:      logger,
+		models:      models,
+		mailer:      smtpMailer,
+		jwtKeys:     jwtKeys,
+		oidc:        identityProvider,
+		readyChecks: readyChecks,
 	}
 
 	err = app.serve()
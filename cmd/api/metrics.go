@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "greenlight",
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests served, by route pattern, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "greenlight",
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to serve HTTP requests, by route pattern, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	rateLimitRejectionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "greenlight",
+		Name:      "rate_limit_rejections_total",
+		Help:      "Number of requests rejected by the rate limiter.",
+	})
+)
+
+// unmatchedRoute labels requests that didn't match any route, so that
+// arbitrary URLs can't create new label values.
+const unmatchedRoute = "unmatched"
+
+// otherMethod labels requests with a non-standard method, for the same reason.
+const otherMethod = "OTHER"
+
+// metricsMethod returns the method label for a request method.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return otherMethod
+	}
+}
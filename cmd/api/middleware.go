@@ -1,18 +1,24 @@
 package main
 
 import (
+	"crypto/rand"
 	"errors"
-	"expvar"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/recchia/greenlight/internal/data"
 	"github.com/recchia/greenlight/internal/validator"
 	"github.com/tomasen/realip"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/time/rate"
 )
 
@@ -68,6 +74,7 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 
 			if !clients[ip].limiter.Allow() {
 				mu.Unlock()
+				rateLimitRejectionsTotal.Inc()
 				app.rateLimitExceededResponse(w, r)
 				return
 			}
@@ -91,14 +98,61 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		scheme, token, err := app.readAuthorization(r)
+		if err != nil {
 			app.invalidAuthenticationTokenResponse(w, r)
 
 			return
 		}
 
-		token := headerParts[1]
+		if scheme == "ApiKey" {
+			app.authenticateAPIKey(w, r, token, next)
+
+			return
+		}
+
+		if scheme != "Bearer" {
+			app.invalidAuthenticationTokenResponse(w, r)
+
+			return
+		}
+
+		if app.jwtKeys != nil && isJWT(token) {
+			user, permissions, tokenVersion, err := app.parseJWT(token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+
+				return
+			}
+
+			// A JWT can't be deleted, so it is revoked by incrementing the
+			// user's token version instead. Deleted users have none.
+			currentVersion, err := app.models.Users.GetTokenVersion(r.Context(), user.ID)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+
+				return
+			}
+
+			if tokenVersion != currentVersion {
+				app.invalidAuthenticationTokenResponse(w, r)
+
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetPermissions(r, permissions)
+
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
 		v := validator.New()
 
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
@@ -107,7 +161,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -125,6 +179,59 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	})
 }
 
+// authenticateAPIKey authenticates a request made with an "ApiKey" authorization
+// header. The request is granted the key's permissions that the owner still
+// holds.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, plaintext string, next http.Handler) {
+	v := validator.New()
+
+	if data.ValidateAPIKeyPlaintext(v, plaintext); !v.Valid() {
+		app.invalidAPIKeyResponse(w, r)
+		return
+	}
+
+	key, err := app.models.APIKeys.GetByPlaintext(r.Context(), plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(r.Context(), key.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	owner, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Failing to record when the key was last used shouldn't fail the
+	// request itself.
+	err = app.models.APIKeys.MarkUsed(r.Context(), key)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetPermissions(r, key.EffectivePermissions(owner))
+	r = app.contextSetAPIKey(r, key)
+
+	next.ServeHTTP(w, r)
+}
+
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
@@ -152,15 +259,36 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	return app.requireAuthenticatedUser(fn)
 }
 
-func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+// requireInteractiveUser rejects requests authenticated with an API key, so
+// that a leaked key can't be used to take over the account it belongs to:
+// minting further keys, changing credentials or revoking the owner's sessions.
+func (app *application) requireInteractiveUser(next http.HandlerFunc) http.HandlerFunc {
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user := app.contextGetUser(r)
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
+		if _, ok := app.contextGetAPIKey(r); ok {
+			app.apiKeyNotAllowedResponse(w, r)
 			return
 		}
 
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		permissions, ok := app.contextGetPermissions(r)
+		if !ok {
+			user := app.contextGetUser(r)
+
+			var err error
+			permissions, err = app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
 		if !permissions.Has(code) {
 			app.notPermittedResponse(w, r)
 			return
@@ -203,6 +331,7 @@ type metricsResponseWriter struct {
 	wrapped       http.ResponseWriter
 	statusCode    int
 	headerWritten bool
+	bytesWritten  int
 }
 
 func newMetricsResponseWriter(w http.ResponseWriter) *metricsResponseWriter {
@@ -227,28 +356,120 @@ func (w *metricsResponseWriter) WriteHeader(statusCode int) {
 
 func (w *metricsResponseWriter) Write(b []byte) (int, error) {
 	w.headerWritten = true
-	return w.wrapped.Write(b)
+	n, err := w.wrapped.Write(b)
+	w.bytesWritten += n
+	return n, err
 }
 
 func (w *metricsResponseWriter) Unwrap() http.ResponseWriter {
 	return w.wrapped
 }
 
-var (
-	totalRequestsReceived           = expvar.NewInt("total_requests_received")
-	totalResponsesSent              = expvar.NewInt("total_responses_sent")
-	totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_microseconds")
-	totalResponsesSentByStatusCode  = expvar.NewMap("total_responses_sent_by_status_code")
-)
-
 func (app *application) metrics(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		totalRequestsReceived.Add(1)
 		mw := newMetricsResponseWriter(w)
+
+		next.ServeHTTP(mw, r)
+
+		route := app.contextGetRequestInfo(r).route
+		if route == "" {
+			route = unmatchedRoute
+		}
+
+		labels := prometheus.Labels{"route": route, "method": metricsMethod(r.Method), "status": strconv.Itoa(mw.statusCode)}
+
+		httpRequestsTotal.With(labels).Inc()
+		httpRequestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// trace starts a server span for each request, continuing the trace from an
+// incoming W3C traceparent header if there is one. The span is named after
+// the route pattern once the request has been routed.
+func (app *application) trace(next http.Handler) http.Handler {
+	tracer := otel.Tracer("github.com/recchia/greenlight/cmd/api")
+	propagator := propagation.TraceContext{}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := tracer.Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		mw := newMetricsResponseWriter(w)
+		r = r.WithContext(ctx)
+
+		next.ServeHTTP(mw, r)
+
+		if route := app.contextGetRequestInfo(r).route; route != "" {
+			span.SetName(route)
+			span.SetAttributes(attribute.String("http.route", route))
+		}
+
+		span.SetAttributes(attribute.Int("http.response.status_code", mw.statusCode))
+
+		if mw.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(mw.statusCode))
+		}
+	})
+}
+
+// logRequest assigns each request an ID, taken from the X-Request-ID header
+// if the client or a proxy in front of us already set a usable one, and
+// writes an access log line once the request has been served.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = rand.Text()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		r = app.contextSetRequestID(r, id)
+		r, info := app.contextSetRequestInfo(r)
+		mw := newMetricsResponseWriter(w)
+
 		next.ServeHTTP(mw, r)
-		totalResponsesSent.Add(1)
-		totalResponsesSentByStatusCode.Add(strconv.Itoa(mw.statusCode), 1)
-		totalProcessingTimeMicroseconds.Add(int64(time.Since(start) / time.Microsecond))
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("uri", r.URL.RequestURI()),
+			slog.String("route", info.route),
+			slog.Int("status", mw.statusCode),
+			slog.Int("bytes", mw.bytesWritten),
+			slog.Duration("duration", time.Since(start)),
+		}
+
+		if info.userID != 0 {
+			attrs = append(attrs, slog.Int64("user_id", info.userID))
+		}
+
+		app.logger.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
 	})
 }
+
+// validRequestID reports whether an incoming request ID is safe to reuse in
+// logs and response headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"strings"
 
 	"github.com/recchia/greenlight/internal/data"
 	"github.com/recchia/greenlight/internal/validator"
@@ -38,7 +43,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(r.Context(), movie)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -55,6 +60,166 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+const (
+	// maxBatchSize is the most movies a single batch import may contain.
+	maxBatchSize = 1000
+
+	batchModeAtomic  = "atomic"
+	batchModePartial = "partial"
+
+	batchStatusCreated = "created"
+	batchStatusInvalid = "invalid"
+	batchStatusSkipped = "skipped"
+)
+
+type batchResult struct {
+	Index  int         `json:"index"`
+	Status string      `json:"status"`
+	Movie  *data.Movie `json:"movie,omitzero"`
+}
+
+// createMoviesBatchHandler imports many movies in one request, given as a
+// JSON array or as NDJSON (one movie per line). In atomic mode, the default,
+// nothing is stored unless every movie is valid; in partial mode the valid
+// movies are stored and the invalid ones reported. Either way the response
+// holds a status for every movie and the validation errors keyed by index.
+func (app *application) createMoviesBatchHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	mode := app.readString(r.URL.Query(), "mode", batchModeAtomic)
+	if v.Check(validator.PermittedValues(mode, batchModeAtomic, batchModePartial), "mode", "must be atomic or partial"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, err := app.readMoviesBatch(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var (
+		results  = make([]batchResult, len(movies))
+		errs     = make(map[int]map[string]string)
+		toInsert []*data.Movie
+	)
+
+	for i, movie := range movies {
+		v := validator.New()
+
+		if data.ValidateMovie(v, movie); !v.Valid() {
+			results[i] = batchResult{Index: i, Status: batchStatusInvalid}
+			errs[i] = v.Errors
+			continue
+		}
+
+		results[i] = batchResult{Index: i, Status: batchStatusSkipped}
+		toInsert = append(toInsert, movie)
+	}
+
+	if len(toInsert) == 0 || (mode == batchModeAtomic && len(errs) > 0) {
+		app.writeBatchResponse(w, r, http.StatusUnprocessableEntity, results, errs)
+		return
+	}
+
+	err = app.models.Movies.InsertMany(r.Context(), toInsert)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for i, movie := range movies {
+		if results[i].Status == batchStatusSkipped {
+			results[i] = batchResult{Index: i, Status: batchStatusCreated, Movie: movie}
+		}
+	}
+
+	status := http.StatusCreated
+	if len(errs) > 0 {
+		status = http.StatusMultiStatus
+	}
+
+	app.writeBatchResponse(w, r, status, results, errs)
+}
+
+// readMoviesBatch decodes the movies in the request body, which is NDJSON if
+// the Content-Type says so and a JSON array otherwise.
+func (app *application) readMoviesBatch(w http.ResponseWriter, r *http.Request) ([]*data.Movie, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, 10*1_048_576)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	ndjson := mediaType == "application/x-ndjson" || mediaType == "application/jsonl"
+
+	if !ndjson {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, jsonDecodeError(err)
+		}
+		if token != json.Delim('[') {
+			return nil, errors.New("body must contain a JSON array of movies")
+		}
+	}
+
+	var movies []*data.Movie
+
+	for decoder.More() {
+		if len(movies) == maxBatchSize {
+			return nil, fmt.Errorf("body must not contain more than %d movies", maxBatchSize)
+		}
+
+		var input struct {
+			Title   string       `json:"title"`
+			Year    int32        `json:"year"`
+			Runtime data.Runtime `json:"runtime"`
+			Genres  []string     `json:"genres"`
+		}
+
+		err := decoder.Decode(&input)
+		if err != nil {
+			return nil, fmt.Errorf("movie %d: %w", len(movies), jsonDecodeError(err))
+		}
+
+		movies = append(movies, &data.Movie{
+			Title:   input.Title,
+			Year:    input.Year,
+			Runtime: input.Runtime,
+			Genres:  input.Genres,
+		})
+	}
+
+	if !ndjson {
+		_, err := decoder.Token()
+		if err != nil {
+			return nil, jsonDecodeError(err)
+		}
+	}
+
+	_, err := decoder.Token()
+	if !errors.Is(err, io.EOF) {
+		return nil, errors.New("body must only contain movies")
+	}
+
+	if len(movies) == 0 {
+		return nil, errors.New("body must contain at least one movie")
+	}
+
+	return movies, nil
+}
+
+func (app *application) writeBatchResponse(w http.ResponseWriter, r *http.Request, status int, results []batchResult, errs map[int]map[string]string) {
+	env := envelope{"results": results}
+	if len(errs) > 0 {
+		env["errors"] = errs
+	}
+
+	err := app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 
@@ -63,7 +228,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
 			app.notFoundResponse(w, r)
@@ -76,7 +241,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 
 	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
-		app.logger.Error(err.Error())
+		app.logger.ErrorContext(r.Context(), err.Error())
 		app.serverErrorResponse(w, r, err)
 	}
 }
@@ -88,7 +253,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
 			app.notFoundResponse(w, r)
@@ -132,7 +297,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(r.Context(), movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -157,7 +322,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	err = app.models.Movies.Delete(r.Context(), id)
 
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
@@ -178,8 +343,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieCriteria
 		data.Filters
 	}
 
@@ -187,18 +351,38 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	qs := r.URL.Query()
 
 	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.YearMin = app.readInt(qs, "year_min", 0, v)
+	input.YearMax = app.readInt(qs, "year_max", 0, v)
+	input.RuntimeMin = app.readInt(qs, "runtime_min", 0, v)
+	input.RuntimeMax = app.readInt(qs, "runtime_max", 0, v)
+	// genres is the original name of genres_all and is still accepted.
+	input.GenresAll = app.readCSV(qs, "genres_all", app.readCSV(qs, "genres", []string{}))
+	input.GenresAny = app.readCSV(qs, "genres_any", []string{})
+	input.ExcludeGenres = app.readCSV(qs, "exclude_genres", []string{})
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Search = app.readString(qs, "search", "")
+
+	defaultSort := "id"
+	if input.Search != "" {
+		defaultSort = "relevance"
+	}
+
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "relevance", "-id", "-title", "-year", "-runtime"}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.CursorKey = app.config.cursor.key
+	input.Filters.CursorScope = input.MovieCriteria.CursorScope()
+
+	data.ValidateMovieCriteria(v, input.MovieCriteria)
+	v.Check(input.Sort != "relevance" || input.Search != "", "sort", "relevance requires a search")
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.MovieCriteria, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -209,3 +393,43 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// suggestMoviesHandler offers titles for a partially typed query. It answers
+// within the suggestion latency budget: a lookup that takes longer is given
+// up in favour of an empty list, since the user has typed on by then.
+func (app *application) suggestMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	prefix := strings.ToLower(strings.Join(strings.Fields(app.readString(qs, "q", "")), " "))
+	limit := app.readInt(qs, "limit", 10, v)
+
+	v.Check(prefix != "", "q", "must be provided")
+	v.Check(len(prefix) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 20, "limit", "must be a maximum of 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.suggest.timeout)
+	defer cancel()
+
+	suggestions, err := app.models.Movies.Suggest(ctx, prefix, limit)
+	if err != nil {
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) || r.Context().Err() != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.logger.WarnContext(r.Context(), "movie suggestions exceeded their latency budget", "q", prefix)
+		suggestions = []data.Suggestion{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
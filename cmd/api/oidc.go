@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/recchia/greenlight/internal/data"
+	"github.com/recchia/greenlight/internal/validator"
+	"golang.org/x/oauth2"
+)
+
+// oidcLoginTTL is how long a user has to complete a login at the provider.
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcProvider is an external OpenID Connect identity provider that users can
+// log in with instead of a password.
+type oidcProvider struct {
+	issuer   string
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// newOIDCProvider discovers the provider's endpoints and signing keys from
+// its issuer URL.
+func newOIDCProvider(ctx context.Context, issuer, clientID, clientSecret, redirectURL string) (*oidcProvider, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidcProvider{
+		issuer: issuer,
+		oauth2: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}, nil
+}
+
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// oidcAuthorizeHandler starts an authorization code login with PKCE by
+// redirecting to the provider.
+func (app *application) oidcAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	login := &data.OIDCLogin{
+		State:        rand.Text(),
+		CodeVerifier: oauth2.GenerateVerifier(),
+		Nonce:        rand.Text(),
+		Expiry:       time.Now().Add(oidcLoginTTL),
+	}
+
+	err := app.models.OIDCLogins.Insert(r.Context(), login)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	url := app.oidc.oauth2.AuthCodeURL(login.State, oidc.Nonce(login.Nonce), oauth2.S256ChallengeOption(login.CodeVerifier))
+
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// oidcCallbackHandler finishes a login once the provider redirects back,
+// responding with the same tokens as a password login.
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+
+	if errorCode := qs.Get("error"); errorCode != "" {
+		app.externalLoginFailedResponse(w, r, fmt.Errorf("provider returned %s: %s", errorCode, qs.Get("error_description")))
+		return
+	}
+
+	code := app.readString(qs, "code", "")
+	state := app.readString(qs, "state", "")
+
+	v := validator.New()
+
+	v.Check(code != "", "code", "must be provided")
+	v.Check(state != "", "state", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	login, err := app.models.OIDCLogins.Take(r.Context(), state)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired login state")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	oauth2Token, err := app.oidc.oauth2.Exchange(r.Context(), code, oauth2.VerifierOption(login.CodeVerifier))
+	if err != nil {
+		app.externalLoginFailedResponse(w, r, err)
+		return
+	}
+
+	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
+	if !ok {
+		app.externalLoginFailedResponse(w, r, errors.New("token response has no id_token"))
+		return
+	}
+
+	idToken, err := app.oidc.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		app.externalLoginFailedResponse(w, r, err)
+		return
+	}
+
+	if idToken.Nonce != login.Nonce {
+		app.externalLoginFailedResponse(w, r, errors.New("id_token nonce mismatch"))
+		return
+	}
+
+	var claims oidcClaims
+
+	err = idToken.Claims(&claims)
+	if err != nil {
+		app.externalLoginFailedResponse(w, r, err)
+		return
+	}
+
+	user, err := app.userForIdentity(r.Context(), idToken.Subject, claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnverifiedEmail), errors.Is(err, errDeletedAccount):
+			app.externalLoginFailedResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The identity provider only stands in for the password, so users who
+	// have enabled two-factor authentication still have to provide a code.
+	if app.challengeSecondFactor(w, r, user) {
+		return
+	}
+
+	token, refreshToken, err := app.newAuthenticationTokens(r.Context(), user, rand.Text())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+var (
+	errUnverifiedEmail = errors.New("identity provider did not return a verified email address")
+	errDeletedAccount  = errors.New("email address belongs to an account scheduled for deletion")
+)
+
+// userForIdentity returns the user linked to the external subject. On first
+// login the subject is linked to the user with the same verified email
+// address, which is created if it doesn't exist. Either way the provider has
+// vouched for the address, so the user is activated; an account that was
+// never activated is reclaimed first, since whoever registered it hadn't
+// proved they own the address.
+func (app *application) userForIdentity(ctx context.Context, subject string, claims oidcClaims) (*data.User, error) {
+	user, err := app.models.Users.GetForIdentity(ctx, app.oidc.issuer, subject)
+	if err == nil {
+		return user, nil
+	}
+
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	user, err = app.models.Users.GetByEmail(ctx, claims.Email)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		// Deleted accounts can't be looked up, but keep their email address
+		// until they are purged. Like a password login, logging in to one is
+		// refused rather than restoring it.
+		user, err = app.createExternalUser(ctx, claims)
+		if errors.Is(err, data.ErrDuplicateEmail) {
+			return nil, errDeletedAccount
+		}
+		if err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case !user.Activated:
+		err = app.reclaimUnactivatedUser(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = app.models.Identities.Insert(ctx, &data.Identity{
+		Issuer:  app.oidc.issuer,
+		Subject: subject,
+		UserID:  user.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// reclaimUnactivatedUser activates an account on behalf of the owner of its
+// email address. Anyone could have registered it, so the password they chose
+// is replaced with a random one and every token issued to the account is
+// revoked; otherwise they would keep access once it is linked and activated.
+func (app *application) reclaimUnactivatedUser(ctx context.Context, user *data.User) error {
+	err := user.Password.Set(rand.Text())
+	if err != nil {
+		return err
+	}
+
+	user.PendingEmail = ""
+	user.Activated = true
+
+	err = app.models.Users.Update(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	scopes := []string{
+		data.ScopeActivation,
+		data.ScopeAuthentication,
+		data.ScopePasswordReset,
+		data.ScopeRefresh,
+		data.ScopeEmailChange,
+		data.ScopeTwoFactor,
+	}
+
+	for _, scope := range scopes {
+		err = app.models.Tokens.DeleteAllForUser(ctx, user.ID, scope)
+		if err != nil {
+			return err
+		}
+	}
+
+	return app.models.Users.IncrementTokenVersion(ctx, user.ID)
+}
+
+// createExternalUser creates an activated user for someone logging in through
+// the identity provider. They are given a random password they don't know;
+// they can set one with the password reset flow if they ever need it.
+func (app *application) createExternalUser(ctx context.Context, claims oidcClaims) (*data.User, error) {
+	user := &data.User{
+		Name:      claims.Name,
+		Email:     claims.Email,
+		Activated: true,
+	}
+
+	if user.Name == "" {
+		user.Name = claims.Email
+	}
+
+	err := user.Password.Set(rand.Text())
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Users.Insert(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Permissions.AddForUser(ctx, user.ID, "movies:read")
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/recchia/greenlight/internal/data"
+)
+
+// newTestIdentityProvider starts a minimal OpenID Connect provider. The code
+// sent to its token endpoint selects the identity it vouches for: "existing"
+// for the subject linked to the mock user, "new" for an unknown subject,
+// "unactivated", "two-factor" and "deleted" for unknown subjects whose email
+// addresses belong to the matching mock users, and "unverified" for one whose
+// email address isn't verified.
+func newTestIdentityProvider(t *testing.T) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	writeJSON := func(w http.ResponseWriter, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                ts.URL,
+			"authorization_endpoint":                ts.URL + "/authorize",
+			"token_endpoint":                        ts.URL + "/token",
+			"jwks_uri":                              ts.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"keys": []map[string]any{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code_verifier") != data.MockOIDCCodeVerifier {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]any{"error": "invalid_grant"})
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":            ts.URL,
+			"aud":            "greenlight",
+			"iat":            time.Now().Unix(),
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"nonce":          data.MockOIDCNonce,
+			"email_verified": true,
+			"name":           "Staff Member",
+		}
+
+		switch r.PostFormValue("code") {
+		case "existing":
+			claims["sub"] = "existing-subject"
+			claims["email"] = "test@example.com"
+		case "new":
+			claims["sub"] = "new-subject"
+			claims["email"] = "staff@example.com"
+		case "unactivated":
+			claims["sub"] = "new-subject"
+			claims["email"] = "inactive@example.com"
+		case "deleted":
+			claims["sub"] = "new-subject"
+			claims["email"] = data.MockDeletedEmail
+		case "two-factor":
+			claims["sub"] = "new-subject"
+			claims["email"] = "2fa@example.com"
+		case "unverified":
+			claims["sub"] = "new-subject"
+			claims["email"] = "staff@example.com"
+			claims["email_verified"] = false
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]any{"error": "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		writeJSON(w, map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     idToken,
+		})
+	})
+
+	return ts
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider := newTestIdentityProvider(t)
+
+	app := newTestApplication(t)
+
+	var err error
+	app.oidc, err = newOIDCProvider(context.Background(), provider.URL, "greenlight", "secret", "http://localhost:4000/v1/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Authorize", func(t *testing.T) {
+		client := ts.Client()
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+
+		rs, err := client.Get(ts.URL + "/v1/oidc/authorize")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Body.Close()
+
+		if rs.StatusCode != http.StatusFound {
+			t.Fatalf("expected status code %d, got %d", http.StatusFound, rs.StatusCode)
+		}
+
+		location, err := url.Parse(rs.Header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(location.String(), provider.URL+"/authorize") {
+			t.Errorf("expected redirect to the provider, got %s", location)
+		}
+
+		qs := location.Query()
+		for _, param := range []string{"state", "nonce", "code_challenge"} {
+			if qs.Get(param) == "" {
+				t.Errorf("expected %s parameter in %s", param, location)
+			}
+		}
+		if qs.Get("code_challenge_method") != "S256" {
+			t.Errorf("expected S256 code challenge, got %q", qs.Get("code_challenge_method"))
+		}
+	})
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+	}{
+		{"Linked identity", "code=existing&state=abc", http.StatusCreated},
+		{"First login", "code=new&state=abc", http.StatusCreated},
+		{"Unactivated account", "code=unactivated&state=abc", http.StatusCreated},
+		{"Two-factor user", "code=two-factor&state=abc", http.StatusAccepted},
+		{"Account scheduled for deletion", "code=deleted&state=abc", http.StatusUnauthorized},
+		{"Unverified email", "code=unverified&state=abc", http.StatusUnauthorized},
+		{"Rejected code", "code=bogus&state=abc", http.StatusUnauthorized},
+		{"Expired state", "code=existing&state=expired", http.StatusUnprocessableEntity},
+		{"Missing code", "state=abc", http.StatusUnprocessableEntity},
+		{"Provider error", "error=access_denied&state=abc", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.get(t, "/v1/oidc/callback?"+tt.query)
+
+			if code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, code)
+			}
+		})
+	}
+
+	t.Run("Two-factor user gets a challenge", func(t *testing.T) {
+		_, _, body := ts.get(t, "/v1/oidc/callback?code=two-factor&state=abc")
+
+		if !strings.Contains(body, `"challenge_token"`) || strings.Contains(body, `"refresh_token"`) {
+			t.Errorf("expected only a challenge token, got %s", body)
+		}
+	})
+}
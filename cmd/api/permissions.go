@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/recchia/greenlight/internal/data"
+	"github.com/recchia/greenlight/internal/validator"
+)
+
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+func (app *application) addUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	codes, ok := app.readPermissionCodes(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Permissions.AddForUser(r.Context(), user.ID, codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Access JWTs embed the permissions they were issued with, so they are
+	// revoked for the change to take effect immediately; clients pick up the
+	// new permissions with their refresh token.
+	err = app.models.Users.IncrementTokenVersion(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+func (app *application) removeUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	codes, ok := app.readPermissionCodes(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Permissions.RemoveForUser(r.Context(), user.ID, codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.IncrementTokenVersion(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// readUserParam loads the user identified by the id path parameter. It sends
+// the appropriate error response and returns false if that isn't possible.
+func (app *application) readUserParam(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return nil, false
+	}
+
+	return user, true
+}
+
+// readPermissionCodes reads and validates a {"permissions": [...]} request body
+// against the permission codes known to the database.
+func (app *application) readPermissionCodes(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	known, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	v := validator.New()
+
+	if data.ValidatePermissionCodes(v, input.Permissions, known); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	return input.Permissions, true
+}
+
+func (app *application) writeUserPermissions(w http.ResponseWriter, r *http.Request, user *data.User) {
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestUserPermissionsHandlers(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Show", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/v1/users/1/permissions")
+
+		if code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+		}
+	})
+
+	t.Run("Unknown user", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/v1/users/2/permissions")
+
+		if code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, code)
+		}
+	})
+
+	t.Run("Grant", func(t *testing.T) {
+		code, _, _ := ts.authJSON(t, http.MethodPut, "/v1/users/1/permissions", map[string]any{"permissions": []string{"movies:write"}})
+
+		if code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+		}
+	})
+
+	t.Run("Grant unknown code", func(t *testing.T) {
+		code, _, _ := ts.authJSON(t, http.MethodPut, "/v1/users/1/permissions", map[string]any{"permissions": []string{"movies:delete"}})
+
+		if code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, code)
+		}
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		code, _, _ := ts.authJSON(t, http.MethodDelete, "/v1/users/1/permissions", map[string]any{"permissions": []string{"movies:write"}})
+
+		if code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+		}
+	})
+
+	t.Run("Anonymous user", func(t *testing.T) {
+		code, _, _ := ts.sendJSON(t, http.MethodPut, "/v1/users/1/permissions", map[string]any{"permissions": []string{"movies:write"}})
+
+		if code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, code)
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/recchia/greenlight/internal/data"
+	"github.com/recchia/greenlight/internal/validator"
+)
+
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeUserRoles(w, r, user)
+}
+
+func (app *application) addUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	codes, ok := app.readRoleCodes(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Roles.AddForUser(r.Context(), user.ID, codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The permissions granted by the role are embedded in access JWTs too.
+	err = app.models.Users.IncrementTokenVersion(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserRoles(w, r, user)
+}
+
+func (app *application) removeUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	codes, ok := app.readRoleCodes(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Roles.RemoveForUser(r.Context(), user.ID, codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.IncrementTokenVersion(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserRoles(w, r, user)
+}
+
+func (app *application) readRoleCodes(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	known, err := app.models.Roles.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	v := validator.New()
+
+	if data.ValidateRoleCodes(v, input.Roles, known); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	return input.Roles, true
+}
+
+func (app *application) writeUserRoles(w http.ResponseWriter, r *http.Request, user *data.User) {
+	roles, err := app.models.Roles.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestUserRolesHandlers(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("List roles", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/v1/roles")
+
+		if code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+		}
+	})
+
+	t.Run("Assign", func(t *testing.T) {
+		code, _, _ := ts.authJSON(t, http.MethodPut, "/v1/users/1/roles", map[string]any{"roles": []string{"editor"}})
+
+		if code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+		}
+	})
+
+	t.Run("Assign unknown role", func(t *testing.T) {
+		code, _, _ := ts.authJSON(t, http.MethodPut, "/v1/users/1/roles", map[string]any{"roles": []string{"owner"}})
+
+		if code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, code)
+		}
+	})
+
+	t.Run("Unassign", func(t *testing.T) {
+		code, _, _ := ts.authJSON(t, http.MethodDelete, "/v1/users/1/roles", map[string]any{"roles": []string{"editor"}})
+
+		if code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+		}
+	})
+
+	t.Run("Unknown user", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/v1/users/2/roles")
+
+		if code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, code)
+		}
+	})
+}
@@ -3,29 +3,123 @@ package main
 import (
 	"expvar"
 	"net/http"
+	"strings"
 
-	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func (app *application) routes() http.Handler {
-	router := httprouter.New()
-	router.NotFound = http.HandlerFunc(app.notFoundResponse)
-	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+	mux := http.NewServeMux()
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	mux.HandleFunc("GET /v1/healthcheck", app.healthcheckHandler)
 
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	mux.HandleFunc("GET /v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
+	mux.HandleFunc("POST /v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
+	mux.HandleFunc("POST /v1/movies/batch", app.requirePermission("movies:write", app.createMoviesBatchHandler))
+	mux.HandleFunc("GET /v1/movies/suggest", app.requirePermission("movies:read", app.suggestMoviesHandler))
+	mux.HandleFunc("GET /v1/movies/{id}", app.requirePermission("movies:read", app.showMovieHandler))
+	mux.HandleFunc("PATCH /v1/movies/{id}", app.requirePermission("movies:write", app.updateMovieHandler))
+	mux.HandleFunc("DELETE /v1/movies/{id}", app.requirePermission("movies:write", app.deleteMovieHandler))
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/users/activated", app.activateUserHandler)
+	mux.HandleFunc("POST /v1/users", app.registerUserHandler)
+	mux.HandleFunc("PATCH /v1/users/activated", app.activateUserHandler)
+	mux.HandleFunc("PUT /v1/users/password", app.updateUserPasswordHandler)
+	mux.HandleFunc("GET /v1/users/me", app.requireInteractiveUser(app.showCurrentUserHandler))
+	mux.HandleFunc("PATCH /v1/users/me", app.requireInteractiveUser(app.updateCurrentUserHandler))
+	mux.HandleFunc("DELETE /v1/users/me", app.requireInteractiveUser(app.deleteCurrentUserHandler))
+	mux.HandleFunc("GET /v1/users/me/export", app.requireInteractiveUser(app.exportCurrentUserHandler))
+	mux.HandleFunc("POST /v1/users/me/email", app.requireActivatedUser(app.requireInteractiveUser(app.requestEmailChangeHandler)))
+	mux.HandleFunc("PUT /v1/users/email", app.confirmEmailChangeHandler)
+	mux.HandleFunc("GET /v1/users/me/api-keys", app.requireActivatedUser(app.requireInteractiveUser(app.listAPIKeysHandler)))
+	mux.HandleFunc("POST /v1/users/me/api-keys", app.requireActivatedUser(app.requireInteractiveUser(app.createAPIKeyHandler)))
+	mux.HandleFunc("GET /v1/users/me/api-keys/{id}", app.requireActivatedUser(app.requireInteractiveUser(app.showAPIKeyHandler)))
+	mux.HandleFunc("PATCH /v1/users/me/api-keys/{id}", app.requireActivatedUser(app.requireInteractiveUser(app.updateAPIKeyHandler)))
+	mux.HandleFunc("DELETE /v1/users/me/api-keys/{id}", app.requireActivatedUser(app.requireInteractiveUser(app.deleteAPIKeyHandler)))
+	mux.HandleFunc("POST /v1/users/me/2fa", app.requireActivatedUser(app.requireInteractiveUser(app.enrolTwoFactorHandler)))
+	mux.HandleFunc("POST /v1/users/me/2fa/confirm", app.requireActivatedUser(app.requireInteractiveUser(app.confirmTwoFactorHandler)))
+	mux.HandleFunc("DELETE /v1/users/me/2fa", app.requireActivatedUser(app.requireInteractiveUser(app.disableTwoFactorHandler)))
 
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	mux.HandleFunc("GET /v1/permissions", app.requirePermission("users:admin", app.listPermissionsHandler))
+	mux.HandleFunc("GET /v1/users/{id}/permissions", app.requirePermission("users:admin", app.showUserPermissionsHandler))
+	mux.HandleFunc("PUT /v1/users/{id}/permissions", app.requirePermission("users:admin", app.addUserPermissionsHandler))
+	mux.HandleFunc("DELETE /v1/users/{id}/permissions", app.requirePermission("users:admin", app.removeUserPermissionsHandler))
 
-	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	mux.HandleFunc("GET /v1/roles", app.requirePermission("users:admin", app.listRolesHandler))
+	mux.HandleFunc("GET /v1/users/{id}/roles", app.requirePermission("users:admin", app.showUserRolesHandler))
+	mux.HandleFunc("PUT /v1/users/{id}/roles", app.requirePermission("users:admin", app.addUserRolesHandler))
+	mux.HandleFunc("DELETE /v1/users/{id}/roles", app.requirePermission("users:admin", app.removeUserRolesHandler))
 
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	mux.HandleFunc("POST /v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	mux.HandleFunc("POST /v1/tokens/two-factor", app.createTwoFactorAuthenticationTokenHandler)
+	mux.HandleFunc("POST /v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	mux.HandleFunc("DELETE /v1/tokens/authentication", app.requireInteractiveUser(app.deleteAuthenticationTokenHandler))
+	mux.HandleFunc("DELETE /v1/tokens/authentication/all", app.requireInteractiveUser(app.deleteAllAuthenticationTokensHandler))
+	mux.HandleFunc("POST /v1/tokens/activation", app.createActivationTokenHandler)
+	mux.HandleFunc("POST /v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	mux.HandleFunc("GET /v1/oidc/authorize", app.oidcAuthorizeHandler)
+	mux.HandleFunc("GET /v1/oidc/callback", app.oidcCallbackHandler)
+
+	mux.HandleFunc("GET /.well-known/jwks.json", app.jwksHandler)
+
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.Handle("GET /metrics", promhttp.Handler())
+
+	// The probes are served ahead of the rate limiter, so that an instance
+	// busy turning clients away isn't also reported as unhealthy.
+	probes := http.NewServeMux()
+	probes.HandleFunc("GET /livez", app.recordRoute(app.livenessHandler))
+	probes.HandleFunc("GET /readyz", app.recordRoute(app.readinessHandler))
+	probes.Handle("/", app.enableCORS(app.rateLimit(app.authenticate(app.unmatchedRoutes(mux)))))
+
+	return app.logRequest(app.metrics(app.trace(app.recoverPanic(probes))))
+}
+
+// recordRoute records the pattern a request matched for handlers that are
+// mounted outside unmatchedRoutes.
+func (app *application) recordRoute(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app.contextGetRequestInfo(r).route = r.Pattern
+		next(w, r)
+	}
+}
+
+// unmatchedRoutes answers requests that don't match any registered pattern
+// with the usual JSON error responses instead of ServeMux's plain text ones.
+// OPTIONS requests for a known path get a 200 listing the allowed methods.
+func (app *application) unmatchedRoutes(mux *http.ServeMux) http.Handler {
+	methods := []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			app.contextGetRequestInfo(r).route = pattern
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		var allowed []string
+
+		for _, method := range methods {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+
+			if _, pattern := mux.Handler(probe); pattern != "" {
+				allowed = append(allowed, method)
+			}
+		}
+
+		if len(allowed) == 0 {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		app.methodNotAllowedResponse(w, r)
+	})
 }
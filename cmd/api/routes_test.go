@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUnmatchedRoutes(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Not found", func(t *testing.T) {
+		code, headers, body := ts.get(t, "/v1/does-not-exist")
+
+		if code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, code)
+		}
+		if headers.Get("Content-Type") != "application/json" || !strings.Contains(body, `"error"`) {
+			t.Errorf("expected JSON error response, got %q", body)
+		}
+	})
+
+	t.Run("Method not allowed", func(t *testing.T) {
+		code, headers, body := ts.authJSON(t, http.MethodPut, "/v1/movies/1", nil)
+
+		if code != http.StatusMethodNotAllowed {
+			t.Errorf("expected status code %d, got %d", http.StatusMethodNotAllowed, code)
+		}
+		if headers.Get("Allow") != "GET, PATCH, DELETE" {
+			t.Errorf("expected Allow: GET, PATCH, DELETE, got %q", headers.Get("Allow"))
+		}
+		if !strings.Contains(body, `"error"`) {
+			t.Errorf("expected JSON error response, got %q", body)
+		}
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		code, headers, _ := ts.authJSON(t, http.MethodOptions, "/v1/movies/1", nil)
+
+		if code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+		}
+		if headers.Get("Allow") != "GET, PATCH, DELETE" {
+			t.Errorf("expected Allow: GET, PATCH, DELETE, got %q", headers.Get("Allow"))
+		}
+	})
+
+	t.Run("Options not found", func(t *testing.T) {
+		code, _, _ := ts.authJSON(t, http.MethodOptions, "/v1/does-not-exist", nil)
+
+		if code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, code)
+		}
+	})
+}
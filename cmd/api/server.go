@@ -24,6 +24,13 @@ func (app *application) serve() error {
 
 	shutdownError := make(chan error)
 
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	app.wg.Go(func() {
+		app.purgeDeletedUsers(jobsCtx)
+	})
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -31,6 +38,11 @@ func (app *application) serve() error {
 
 		app.logger.Info("Shutting down server", slog.String("signal", s.String()))
 
+		// Fail readiness first so load balancers stop sending new requests
+		// before the listener is closed.
+		app.shuttingDown.Store(true)
+		time.Sleep(app.config.readiness.drainDelay)
+
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
@@ -41,6 +53,7 @@ func (app *application) serve() error {
 
 		app.logger.Info("completing background tasks", "addr", srv.Addr)
 
+		stopJobs()
 		app.wg.Wait()
 		shutdownError <- nil
 	}()
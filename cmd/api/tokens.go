@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -31,10 +34,29 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	emailKey, ipKey := app.loginAttemptKeys(r, input.Email)
+
+	retryAfter, err := app.loginLockout(r.Context(), emailKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if retryAfter > 0 {
+		app.loginLockedResponse(w, r, retryAfter)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.recordLoginFailure(r.Context(), emailKey, ipKey, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -50,17 +72,482 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 
 	if !match {
+		err = app.recordLoginFailure(r.Context(), emailKey, ipKey, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	// The failed login count is left alone until the second factor has been
+	// verified, otherwise knowing the password would be enough to keep
+	// guessing codes indefinitely.
+	if app.challengeSecondFactor(w, r, user) {
+		return
+	}
+
+	app.completeLogin(w, r, user, emailKey)
+}
+
+// challengeSecondFactor responds with a two-factor challenge token if the user
+// has enabled two-factor authentication, to be exchanged for authentication
+// tokens at POST /v1/tokens/two-factor. It reports whether a response has been
+// written, in which case the caller must not issue tokens itself.
+func (app *application) challengeSecondFactor(w http.ResponseWriter, r *http.Request, user *data.User) bool {
+	twoFactor, err := app.models.TwoFactor.Get(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+
+	if twoFactor == nil || !twoFactor.Enabled {
+		return false
+	}
+
+	challenge, err := app.models.Tokens.New(r.Context(), user.ID, app.config.twoFactor.challengeTTL, data.ScopeTwoFactor)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"challenge_token": challenge}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+
+	return true
+}
+
+// completeLogin clears the failed login count for the user's email address
+// and responds with a new pair of authentication and refresh tokens.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User, emailKey string) {
+	err := app.models.LoginAttempts.Reset(r.Context(), emailKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, refreshToken, err := app.newAuthenticationTokens(r.Context(), user, rand.Text())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createTwoFactorAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateTokenPlaintext(v, input.ChallengeToken)
+
+	switch {
+	case input.Code != "" && input.RecoveryCode != "":
+		v.AddError("code", "must not be provided together with recovery_code")
+	case input.RecoveryCode == "":
+		data.ValidateTOTPCode(v, input.Code)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeTwoFactor, input.ChallengeToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired challenge token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	emailKey, ipKey := app.loginAttemptKeys(r, user.Email)
+
+	retryAfter, err := app.loginLockout(r.Context(), emailKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if retryAfter > 0 {
+		app.loginLockedResponse(w, r, retryAfter)
+		return
+	}
+
+	valid, err := app.verifySecondFactor(r.Context(), user, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !valid {
+		err = app.recordLoginFailure(r.Context(), emailKey, ipKey, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), user.ID, data.ScopeTwoFactor)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, user, emailKey)
+}
+
+// newAuthenticationTokens issues a short-lived access token together with a
+// refresh token. Both belong to the given family so that detecting reuse of a
+// rotated refresh token can revoke everything issued from the same login.
+func (app *application) newAuthenticationTokens(ctx context.Context, user *data.User, family string) (*data.Token, *data.Token, error) {
+	var (
+		token *data.Token
+		err   error
+	)
+
+	switch app.config.auth.mode {
+	case authModeJWT:
+		permissions, err := app.models.Permissions.GetAllForUser(ctx, user.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tokenVersion, err := app.models.Users.GetTokenVersion(ctx, user.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		token, err = app.newJWT(user, permissions, tokenVersion, app.config.tokens.accessTTL)
+		if err != nil {
+			return nil, nil, err
+		}
+	default:
+		token, err = app.models.Tokens.NewInFamily(ctx, user.ID, app.config.tokens.accessTTL, data.ScopeAuthentication, family)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	refreshToken, err := app.models.Tokens.NewInFamily(ctx, user.ID, app.config.tokens.refreshTTL, data.ScopeRefresh, family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return token, refreshToken, nil
+}
+
+// revokeAuthenticationTokens revokes every authentication and refresh token
+// issued to the user, including access JWTs, which are rejected once the
+// user's token version has been incremented.
+func (app *application) revokeAuthenticationTokens(ctx context.Context, userID int64) error {
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		err := app.models.Tokens.DeleteAllForUser(ctx, userID, scope)
+		if err != nil {
+			return err
+		}
+	}
+
+	return app.models.Users.IncrementTokenVersion(ctx, userID)
+}
+
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	refreshToken, err := app.models.Tokens.GetByPlaintext(r.Context(), data.ScopeRefresh, input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	if refreshToken.Used {
+		app.revokeTokenFamily(w, r, refreshToken)
+		return
+	}
+
+	err = app.models.Tokens.MarkUsed(r.Context(), refreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.revokeTokenFamily(w, r, refreshToken)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeRefresh, input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	token, newRefreshToken, err := app.newAuthenticationTokens(r.Context(), user, refreshToken.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"token": token, "refresh_token": newRefreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeTokenFamily handles a refresh token that has already been rotated.
+// Such a token should never be presented again, so assume it was stolen and
+// revoke every token issued from the same login.
+func (app *application) revokeTokenFamily(w http.ResponseWriter, r *http.Request, refreshToken *data.Token) {
+	err := app.models.Tokens.DeleteFamily(r.Context(), refreshToken.UserID, refreshToken.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.WarnContext(r.Context(), "refresh token reuse detected, token family revoked", "user_id", refreshToken.UserID)
+
+	app.invalidRefreshTokenResponse(w, r)
+}
+
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	if !user.Activated {
+		v.AddError("email", "user account must be activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 45*time.Minute, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(r.Context(), func(ctx context.Context) {
+		templateData := map[string]any{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(ctx, user.Email, "token_password_reset.html", templateData)
+		if err != nil {
+			app.logger.ErrorContext(ctx, fmt.Sprintf("failed to send password reset email to %s: %v", user.Email, err))
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	if user.Activated {
+		v.AddError("email", "user has already been activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), user.ID, data.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(r.Context(), func(ctx context.Context) {
+		templateData := map[string]any{
+			"activationToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(ctx, user.Email, "token_activation.html", templateData)
+		if err != nil {
+			app.logger.ErrorContext(ctx, fmt.Sprintf("failed to send activation email to %s: %v", user.Email, err))
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to you containing activation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := app.readBearerToken(r)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	if isJWT(token) {
+		app.badRequestResponse(w, r, errors.New("stateless authentication tokens cannot be revoked and expire on their own"))
+		return
+	}
+
+	authenticationToken, err := app.models.Tokens.GetByPlaintext(r.Context(), data.ScopeAuthentication, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	if authenticationToken.Family != "" {
+		err = app.models.Tokens.DeleteFamily(r.Context(), authenticationToken.UserID, authenticationToken.Family)
+	} else {
+		err = app.models.Tokens.Delete(r.Context(), data.ScopeAuthentication, token)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.revokeAuthenticationTokens(r.Context(), user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"token": token}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all authentication tokens successfully revoked"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestCreatePasswordResetTokenHandler(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Known email", func(t *testing.T) {
+		code, _, _ := ts.postJSON(t, "/v1/tokens/password-reset", map[string]any{"email": "test@example.com"})
+
+		if code != http.StatusAccepted {
+			t.Errorf("expected status code %d, got %d", http.StatusAccepted, code)
+		}
+	})
+
+	t.Run("Unknown email", func(t *testing.T) {
+		code, _, _ := ts.postJSON(t, "/v1/tokens/password-reset", map[string]any{"email": "nobody@example.com"})
+
+		if code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, code)
+		}
+	})
+
+	t.Run("Invalid email", func(t *testing.T) {
+		code, _, _ := ts.postJSON(t, "/v1/tokens/password-reset", map[string]any{"email": "invalid-email"})
+
+		if code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, code)
+		}
+	})
+}
+
+func TestCreateActivationTokenHandler(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Inactive user", func(t *testing.T) {
+		code, _, _ := ts.postJSON(t, "/v1/tokens/activation", map[string]any{"email": "inactive@example.com"})
+
+		if code != http.StatusAccepted {
+			t.Errorf("expected status code %d, got %d", http.StatusAccepted, code)
+		}
+	})
+
+	t.Run("Already activated", func(t *testing.T) {
+		code, _, _ := ts.postJSON(t, "/v1/tokens/activation", map[string]any{"email": "test@example.com"})
+
+		if code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, code)
+		}
+	})
+
+	t.Run("Unknown email", func(t *testing.T) {
+		code, _, _ := ts.postJSON(t, "/v1/tokens/activation", map[string]any{"email": "nobody@example.com"})
+
+		if code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, code)
+		}
+	})
+}
+
+func TestDeleteAuthenticationTokenHandler(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Current token", func(t *testing.T) {
+		code, _, _ := ts.delete(t, "/v1/tokens/authentication")
+
+		if code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+		}
+	})
+
+	t.Run("All tokens", func(t *testing.T) {
+		code, _, _ := ts.delete(t, "/v1/tokens/authentication/all")
+
+		if code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+		}
+	})
+
+	t.Run("Anonymous user", func(t *testing.T) {
+		code, _, _ := ts.sendJSON(t, http.MethodDelete, "/v1/tokens/authentication", nil)
+
+		if code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, code)
+		}
+	})
+}
+
+func TestRefreshAuthenticationTokenHandler(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Valid refresh token", func(t *testing.T) {
+		code, _, body := ts.postJSON(t, "/v1/tokens/refresh", map[string]any{"refresh_token": "token26charslong1234567890"})
+
+		if code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, code)
+		}
+
+		var response struct {
+			Token        map[string]any `json:"token"`
+			RefreshToken map[string]any `json:"refresh_token"`
+		}
+
+		err := json.Unmarshal([]byte(body), &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response.Token["token"] == nil || response.RefreshToken["token"] == nil {
+			t.Errorf("expected both an access and a refresh token, got %s", body)
+		}
+	})
+
+	t.Run("Reused refresh token", func(t *testing.T) {
+		code, _, _ := ts.postJSON(t, "/v1/tokens/refresh", map[string]any{"refresh_token": "usedrefreshtoken1234567890"})
+
+		if code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, code)
+		}
+	})
+
+	t.Run("Malformed refresh token", func(t *testing.T) {
+		code, _, _ := ts.postJSON(t, "/v1/tokens/refresh", map[string]any{"refresh_token": "abc"})
+
+		if code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, code)
+		}
+	})
+}
+
+func TestCreateAuthenticationTokenHandler(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Valid credentials", func(t *testing.T) {
+		code, _, _ := ts.postJSON(t, "/v1/tokens/authentication", map[string]any{"email": "test@example.com", "password": "pa$$word123"})
+
+		if code != http.StatusCreated {
+			t.Errorf("expected status code %d, got %d", http.StatusCreated, code)
+		}
+	})
+
+	t.Run("Wrong password", func(t *testing.T) {
+		code, _, _ := ts.postJSON(t, "/v1/tokens/authentication", map[string]any{"email": "test@example.com", "password": "wrong-password"})
+
+		if code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, code)
+		}
+	})
+
+	t.Run("Locked out", func(t *testing.T) {
+		code, headers, _ := ts.postJSON(t, "/v1/tokens/authentication", map[string]any{"email": "locked@example.com", "password": "pa$$word123"})
+
+		if code != http.StatusTooManyRequests {
+			t.Errorf("expected status code %d, got %d", http.StatusTooManyRequests, code)
+		}
+		if headers.Get("Retry-After") == "" {
+			t.Error("expected Retry-After header")
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const (
+	traceExporterNone   = "none"
+	traceExporterStdout = "stdout"
+	traceExporterOTLP   = "otlp"
+)
+
+// newTracerProvider returns a tracer provider that batches spans to the given
+// exporter. The OTLP exporter is configured through the standard
+// OTEL_EXPORTER_OTLP_* environment variables.
+func newTracerProvider(ctx context.Context, exporter string, sampleRatio float64) (*sdktrace.TracerProvider, error) {
+	var (
+		spanExporter sdktrace.SpanExporter
+		err          error
+	)
+
+	switch exporter {
+	case traceExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case traceExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported trace exporter %q", exporter)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewSchemaless(
+		attribute.String("service.name", "greenlight"),
+		attribute.String("service.version", version),
+	)
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	), nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/recchia/greenlight/internal/data"
+	"github.com/recchia/greenlight/internal/totp"
+	"github.com/recchia/greenlight/internal/validator"
+)
+
+func (app *application) enrolTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readCurrentUserPassword(w, r)
+	if !ok {
+		return
+	}
+
+	twoFactor := &data.TwoFactor{
+		UserID: user.ID,
+		Secret: totp.GenerateSecret(),
+	}
+
+	err := app.models.TwoFactor.Enrol(r.Context(), twoFactor)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			v := validator.New()
+			v.AddError("two_factor", "is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"two_factor": envelope{
+		"secret": twoFactor.Secret,
+		"uri":    totp.URI(app.config.twoFactor.issuer, user.Email, twoFactor.Secret),
+	}}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	twoFactor, err := app.models.TwoFactor.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("two_factor", "has not been enrolled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if twoFactor.Enabled {
+		v.AddError("two_factor", "is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	step, ok := totp.Validate(twoFactor.Secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "is invalid")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	recoveryCodes, hashes := data.GenerateRecoveryCodes()
+
+	err = app.models.TwoFactor.Confirm(r.Context(), twoFactor, step, hashes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readCurrentUserPassword(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.TwoFactor.Delete(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication has been disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readCurrentUserPassword reads a {"password": "..."} request body and checks
+// it against the authenticated user's password, so that a stolen token alone
+// can't change how the account logs in. It sends the appropriate error
+// response and returns false if the password doesn't match.
+func (app *application) readCurrentUserPassword(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	v := validator.New()
+
+	if data.ValidatePasswordPlaintext(v, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// verifySecondFactor checks a TOTP code, or failing that a recovery code,
+// for a user who has two-factor authentication enabled. Each code is only
+// accepted once.
+func (app *application) verifySecondFactor(ctx context.Context, user *data.User, code, recoveryCode string) (bool, error) {
+	twoFactor, err := app.models.TwoFactor.Get(ctx, user.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if !twoFactor.Enabled {
+		return false, nil
+	}
+
+	if recoveryCode != "" {
+		err = app.models.TwoFactor.UseRecoveryCode(ctx, user.ID, recoveryCode)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+
+	step, ok := totp.Validate(twoFactor.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	err = app.models.TwoFactor.MarkStepUsed(ctx, twoFactor, step)
+	if err != nil {
+		if errors.Is(err, data.ErrEditConflict) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
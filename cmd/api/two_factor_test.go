@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/recchia/greenlight/internal/data"
+	"github.com/recchia/greenlight/internal/totp"
+)
+
+func TestEnrolTwoFactorHandler(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Valid password", func(t *testing.T) {
+		code, _, body := ts.authJSON(t, http.MethodPost, "/v1/users/me/2fa", map[string]any{"password": "pa$$word123"})
+
+		if code != http.StatusCreated {
+			t.Errorf("expected status code %d, got %d", http.StatusCreated, code)
+		}
+		if !strings.Contains(body, "otpauth://totp/Greenlight:test@example.com") {
+			t.Errorf("expected otpauth URI in response, got %s", body)
+		}
+	})
+
+	t.Run("Wrong password", func(t *testing.T) {
+		code, _, _ := ts.authJSON(t, http.MethodPost, "/v1/users/me/2fa", map[string]any{"password": "wrong-password"})
+
+		if code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, code)
+		}
+	})
+
+	t.Run("Confirm without enrolment", func(t *testing.T) {
+		code, _, _ := ts.authJSON(t, http.MethodPost, "/v1/users/me/2fa/confirm", map[string]any{"code": "123456"})
+
+		if code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, code)
+		}
+	})
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Password returns challenge", func(t *testing.T) {
+		code, _, body := ts.postJSON(t, "/v1/tokens/authentication", map[string]any{"email": "2fa@example.com", "password": "pa$$word123"})
+
+		if code != http.StatusAccepted {
+			t.Errorf("expected status code %d, got %d", http.StatusAccepted, code)
+		}
+		if !strings.Contains(body, "challenge_token") {
+			t.Errorf("expected challenge token in response, got %s", body)
+		}
+	})
+
+	totpCode, err := totp.Code(data.MockTwoFactorSecret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		input    map[string]any
+		wantCode int
+	}{
+		{"Valid code", map[string]any{"code": totpCode}, http.StatusCreated},
+		{"Valid recovery code", map[string]any{"recovery_code": data.MockRecoveryCode}, http.StatusCreated},
+		{"Invalid code", map[string]any{"code": "000000"}, http.StatusUnauthorized},
+		{"Invalid recovery code", map[string]any{"recovery_code": "zzzzz-zzzzz"}, http.StatusUnauthorized},
+		{"Both codes", map[string]any{"code": totpCode, "recovery_code": data.MockRecoveryCode}, http.StatusUnprocessableEntity},
+		{"Missing code", map[string]any{}, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.input["challenge_token"] = "token26charslong1234567890"
+
+			code, _, _ := ts.postJSON(t, "/v1/tokens/two-factor", tt.input)
+
+			if code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, code)
+			}
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -51,3 +53,211 @@ func TestRegisterUserHandler(t *testing.T) {
 		}
 	})
 }
+
+func TestUpdateUserPasswordHandler(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Valid reset", func(t *testing.T) {
+		input := map[string]any{
+			"password": "n3w-pa$$word",
+			"token":    "token26charslong1234567890",
+		}
+
+		code, _, _ := ts.sendJSON(t, http.MethodPut, "/v1/users/password", input)
+
+		if code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+		}
+	})
+
+	t.Run("Short password", func(t *testing.T) {
+		input := map[string]any{
+			"password": "short",
+			"token":    "token26charslong1234567890",
+		}
+
+		code, _, _ := ts.sendJSON(t, http.MethodPut, "/v1/users/password", input)
+
+		if code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, code)
+		}
+	})
+
+	t.Run("Malformed token", func(t *testing.T) {
+		input := map[string]any{
+			"password": "n3w-pa$$word",
+			"token":    "abc",
+		}
+
+		code, _, _ := ts.sendJSON(t, http.MethodPut, "/v1/users/password", input)
+
+		if code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, code)
+		}
+	})
+}
+
+func TestCurrentUserHandlers(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Show", func(t *testing.T) {
+		code, _, body := ts.get(t, "/v1/users/me")
+
+		if code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, code)
+		}
+
+		var response struct {
+			User struct {
+				ID int64 `json:"id"`
+			} `json:"user"`
+			Permissions []string `json:"permissions"`
+		}
+
+		err := json.Unmarshal([]byte(body), &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response.User.ID != 1 || len(response.Permissions) == 0 {
+			t.Errorf("expected user 1 with permissions, got %s", body)
+		}
+	})
+
+	t.Run("Update name", func(t *testing.T) {
+		code, _, body := ts.authJSON(t, http.MethodPatch, "/v1/users/me", map[string]any{"name": "Alice"})
+
+		if code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+		}
+		if !strings.Contains(body, `"name": "Alice"`) {
+			t.Errorf("expected updated name in response, got %s", body)
+		}
+	})
+
+	t.Run("Empty name", func(t *testing.T) {
+		code, _, _ := ts.authJSON(t, http.MethodPatch, "/v1/users/me", map[string]any{"name": ""})
+
+		if code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, code)
+		}
+	})
+
+	t.Run("Anonymous user", func(t *testing.T) {
+		code, _, _ := ts.sendJSON(t, http.MethodGet, "/v1/users/me", nil)
+
+		if code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, code)
+		}
+	})
+}
+
+func TestEmailChangeHandlers(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Request", func(t *testing.T) {
+		input := map[string]any{"email": "new@example.com", "password": "pa$$word123"}
+
+		code, _, _ := ts.authJSON(t, http.MethodPost, "/v1/users/me/email", input)
+
+		if code != http.StatusAccepted {
+			t.Errorf("expected status code %d, got %d", http.StatusAccepted, code)
+		}
+	})
+
+	t.Run("Wrong password", func(t *testing.T) {
+		input := map[string]any{"email": "new@example.com", "password": "wrong-password"}
+
+		code, _, _ := ts.authJSON(t, http.MethodPost, "/v1/users/me/email", input)
+
+		if code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, code)
+		}
+	})
+
+	t.Run("Address in use", func(t *testing.T) {
+		input := map[string]any{"email": "inactive@example.com", "password": "pa$$word123"}
+
+		code, _, _ := ts.authJSON(t, http.MethodPost, "/v1/users/me/email", input)
+
+		if code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, code)
+		}
+	})
+
+	t.Run("Confirm", func(t *testing.T) {
+		code, _, body := ts.sendJSON(t, http.MethodPut, "/v1/users/email", map[string]any{"token": "token26charslong1234567890"})
+
+		if code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+		}
+		if !strings.Contains(body, `"email": "new@example.com"`) {
+			t.Errorf("expected new email in response, got %s", body)
+		}
+	})
+}
+
+func TestDeleteCurrentUserHandler(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Valid password", func(t *testing.T) {
+		code, _, _ := ts.authJSON(t, http.MethodDelete, "/v1/users/me", map[string]any{"password": "pa$$word123"})
+
+		if code != http.StatusAccepted {
+			t.Errorf("expected status code %d, got %d", http.StatusAccepted, code)
+		}
+	})
+
+	t.Run("Wrong password", func(t *testing.T) {
+		code, _, _ := ts.authJSON(t, http.MethodDelete, "/v1/users/me", map[string]any{"password": "wrong-password"})
+
+		if code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, code)
+		}
+	})
+}
+
+func TestExportCurrentUserHandler(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, headers, body := ts.get(t, "/v1/users/me/export")
+
+	if code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, code)
+	}
+
+	if !strings.HasPrefix(headers.Get("Content-Disposition"), "attachment") {
+		t.Errorf("expected attachment Content-Disposition, got %q", headers.Get("Content-Disposition"))
+	}
+
+	var response struct {
+		Export struct {
+			User        map[string]any   `json:"user"`
+			Permissions []string         `json:"permissions"`
+			Tokens      []map[string]any `json:"tokens"`
+		} `json:"export"`
+	}
+
+	err := json.Unmarshal([]byte(body), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Export.User == nil || len(response.Export.Tokens) != 1 {
+		t.Errorf("expected user and token metadata in export, got %s", body)
+	}
+
+	if _, found := response.Export.Tokens[0]["token"]; found {
+		t.Error("expected token plaintext to be excluded from export")
+	}
+}
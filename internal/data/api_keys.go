@@ -0,0 +1,269 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/recchia/greenlight/internal/validator"
+)
+
+// APIKey is a long-lived credential that lets a program act on behalf of its
+// owner, limited to the listed permission codes. The plaintext key is only
+// available when the key is created.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Plaintext   string      `json:"key,omitzero"`
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+	Version     int32       `json:"version"`
+}
+
+// EffectivePermissions returns the key's permissions that its owner still
+// holds, so that revoking a permission from a user also revokes it from
+// their keys.
+func (k *APIKey) EffectivePermissions(owner Permissions) Permissions {
+	permissions := Permissions{}
+
+	for _, code := range k.Permissions {
+		if owner.Has(code) {
+			permissions = append(permissions, code)
+		}
+	}
+
+	return permissions
+}
+
+// ValidateAPIKey checks the key's name and that its permissions are a subset
+// of the ones held by its owner.
+func ValidateAPIKey(v *validator.Validator, key *APIKey, owner Permissions) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(key.Permissions) > 0, "permissions", "must contain at least one permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+
+	for _, code := range key.Permissions {
+		v.Check(owner.Has(code), "permissions", fmt.Sprintf("you do not hold the %q permission", code))
+	}
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(plaintext != "", "key", "must be provided")
+	v.Check(len(plaintext) == 26, "key", "must be 26 bytes long")
+}
+
+func generateAPIKey(userID int64, name string, permissions Permissions) *APIKey {
+	key := &APIKey{
+		UserID:      userID,
+		Name:        name,
+		Plaintext:   rand.Text(),
+		Permissions: permissions,
+	}
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	return key
+}
+
+type APIKeyModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (m APIKeyModel) New(ctx context.Context, userID int64, name string, permissions Permissions) (*APIKey, error) {
+	ctx, span := startSpan(ctx, "APIKeyModel.New")
+	defer span.End()
+
+	key := generateAPIKey(userID, name, permissions)
+	err := m.Insert(ctx, key)
+
+	return key, err
+}
+
+func (m APIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	ctx, span := startSpan(ctx, "APIKeyModel.Insert")
+	defer span.End()
+
+	query := `INSERT INTO api_keys (user_id, name, hash, permissions)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+	args := []any{key.UserID, key.Name, key.Hash, pq.Array(key.Permissions)}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt, &key.Version)
+}
+
+// Get returns the key with the given id, provided it belongs to userID.
+func (m APIKeyModel) Get(ctx context.Context, id, userID int64) (*APIKey, error) {
+	ctx, span := startSpan(ctx, "APIKeyModel.Get")
+	defer span.End()
+
+	query := `SELECT id, user_id, name, permissions, created_at, last_used_at, version
+		FROM api_keys
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return scanAPIKey(m.DB.QueryRowContext(ctx, query, id, userID))
+}
+
+func (m APIKeyModel) GetByPlaintext(ctx context.Context, plaintext string) (*APIKey, error) {
+	ctx, span := startSpan(ctx, "APIKeyModel.GetByPlaintext")
+	defer span.End()
+
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `SELECT id, user_id, name, permissions, created_at, last_used_at, version
+		FROM api_keys
+		WHERE hash = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return scanAPIKey(m.DB.QueryRowContext(ctx, query, hash[:]))
+}
+
+func (m APIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	ctx, span := startSpan(ctx, "APIKeyModel.GetAllForUser")
+	defer span.End()
+
+	query := `SELECT id, user_id, name, permissions, created_at, last_used_at, version
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (m APIKeyModel) Update(ctx context.Context, key *APIKey) error {
+	ctx, span := startSpan(ctx, "APIKeyModel.Update")
+	defer span.End()
+
+	query := `UPDATE api_keys SET name = $1, permissions = $2, version = version + 1
+		WHERE id = $3 AND user_id = $4 AND version = $5
+		RETURNING version`
+	args := []any{key.Name, pq.Array(key.Permissions), key.ID, key.UserID, key.Version}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&key.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEditConflict
+		}
+		return err
+	}
+
+	return nil
+}
+
+// MarkUsed records that the key has just been used. To avoid a write on
+// every request the timestamp is only updated once a minute.
+func (m APIKeyModel) MarkUsed(ctx context.Context, key *APIKey) error {
+	ctx, span := startSpan(ctx, "APIKeyModel.MarkUsed")
+	defer span.End()
+
+	query := `UPDATE api_keys SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key.ID)
+
+	return err
+}
+
+func (m APIKeyModel) Delete(ctx context.Context, id, userID int64) error {
+	ctx, span := startSpan(ctx, "APIKeyModel.Delete")
+	defer span.End()
+
+	query := `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		pq.Array(&key.Permissions),
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
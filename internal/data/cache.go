@@ -0,0 +1,422 @@
+package data
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"expvar"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	cacheHits   = expvar.NewMap("cache_hits")
+	cacheMisses = expvar.NewMap("cache_misses")
+)
+
+type cacheEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// ttlCache is a size bounded, least recently used cache whose entries also
+// expire after a fixed time to live. It is safe for concurrent use.
+type ttlCache[K comparable, V any] struct {
+	name  string
+	ttl   time.Duration
+	size  int
+	mu    sync.Mutex
+	order *list.List
+	items map[K]*list.Element
+}
+
+func newTTLCache[K comparable, V any](name string, ttl time.Duration, size int) *ttlCache[K, V] {
+	return &ttlCache[K, V]{
+		name:  name,
+		ttl:   ttl,
+		size:  size,
+		order: list.New(),
+		items: make(map[K]*list.Element),
+	}
+}
+
+func (c *ttlCache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, found := c.items[key]; found {
+		entry := el.Value.(*cacheEntry[K, V])
+		if time.Now().Before(entry.expires) {
+			c.order.MoveToFront(el)
+			cacheHits.Add(c.name, 1)
+			return entry.value, true
+		}
+
+		c.removeElement(el)
+	}
+
+	cacheMisses.Add(c.name, 1)
+
+	var zero V
+	return zero, false
+}
+
+func (c *ttlCache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, found := c.items[key]; found {
+		c.removeElement(el)
+	}
+
+	entry := &cacheEntry[K, V]{key: key, value: value, expires: time.Now().Add(c.ttl)}
+	c.items[key] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *ttlCache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, found := c.items[key]; found {
+		c.removeElement(el)
+	}
+}
+
+// DeleteFunc removes every entry for which fn returns true.
+func (c *ttlCache[K, V]) DeleteFunc(fn func(key K, value V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		entry := el.Value.(*cacheEntry[K, V])
+		if fn(entry.key, entry.value) {
+			c.removeElement(el)
+		}
+		el = next
+	}
+}
+
+func (c *ttlCache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *ttlCache[K, V]) removeElement(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry[K, V]).key)
+}
+
+type tokenCacheKey struct {
+	scope string
+	hash  [32]byte
+}
+
+type suggestionCacheKey struct {
+	prefix string
+	limit  int
+}
+
+// modelCaches holds the caches shared by the cached models, so that a change
+// made through one model can invalidate entries cached by another.
+type modelCaches struct {
+	permissions   *ttlCache[int64, Permissions]
+	suggestions   *ttlCache[suggestionCacheKey, []Suggestion]
+	tokenVersions *ttlCache[int64, int]
+	users         *ttlCache[tokenCacheKey, User]
+}
+
+func (c *modelCaches) invalidateUser(userID int64) {
+	c.permissions.Delete(userID)
+	c.tokenVersions.Delete(userID)
+	c.users.DeleteFunc(func(_ tokenCacheKey, user User) bool {
+		return user.ID == userID
+	})
+}
+
+// invalidateSuggestions drops every cached suggestion, since any change to a
+// title can affect the suggestions for many prefixes.
+func (c *modelCaches) invalidateSuggestions() {
+	c.suggestions.DeleteFunc(func(suggestionCacheKey, []Suggestion) bool {
+		return true
+	})
+}
+
+// NewCachedModels wraps the permission, user and token version lookups made on
+// every authenticated request, and the title suggestions for prefixes being typed,
+// with an in-process cache. Writes made through the
+// returned models invalidate the affected entries immediately; changes made
+// elsewhere (another instance or manual SQL) are picked up once the ttl
+// expires.
+func NewCachedModels(models Models, ttl time.Duration, size int) Models {
+	caches := &modelCaches{
+		permissions:   newTTLCache[int64, Permissions]("permissions", ttl, size),
+		suggestions:   newTTLCache[suggestionCacheKey, []Suggestion]("suggestions", ttl, size),
+		tokenVersions: newTTLCache[int64, int]("token_versions", ttl, size),
+		users:         newTTLCache[tokenCacheKey, User]("users", ttl, size),
+	}
+
+	cached := models
+	cached.Movies = cachedMovieModel{models: models, caches: caches}
+	cached.Permissions = cachedPermissionModel{models: models, caches: caches}
+	cached.Roles = cachedRoleModel{models: models, caches: caches}
+	cached.Tokens = cachedTokenModel{models: models, caches: caches}
+	cached.Users = cachedUserModel{models: models, caches: caches}
+
+	return cached
+}
+
+type cachedMovieModel struct {
+	models Models
+	caches *modelCaches
+}
+
+func (m cachedMovieModel) Insert(ctx context.Context, movie *Movie) error {
+	defer m.caches.invalidateSuggestions()
+
+	return m.models.Movies.Insert(ctx, movie)
+}
+
+func (m cachedMovieModel) InsertMany(ctx context.Context, movies []*Movie) error {
+	defer m.caches.invalidateSuggestions()
+
+	return m.models.Movies.InsertMany(ctx, movies)
+}
+
+func (m cachedMovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	return m.models.Movies.Get(ctx, id)
+}
+
+func (m cachedMovieModel) Update(ctx context.Context, movie *Movie) error {
+	defer m.caches.invalidateSuggestions()
+
+	return m.models.Movies.Update(ctx, movie)
+}
+
+func (m cachedMovieModel) Delete(ctx context.Context, id int64) error {
+	defer m.caches.invalidateSuggestions()
+
+	return m.models.Movies.Delete(ctx, id)
+}
+
+func (m cachedMovieModel) GetAll(ctx context.Context, criteria MovieCriteria, filters Filters) ([]*Movie, Metadata, error) {
+	return m.models.Movies.GetAll(ctx, criteria, filters)
+}
+
+// Suggest caches the suggestions for each prefix, as the short prefixes typed
+// first are shared by most users and cost the most to look up.
+func (m cachedMovieModel) Suggest(ctx context.Context, prefix string, limit int) ([]Suggestion, error) {
+	key := suggestionCacheKey{prefix: prefix, limit: limit}
+
+	if suggestions, found := m.caches.suggestions.Get(key); found {
+		return slices.Clone(suggestions), nil
+	}
+
+	suggestions, err := m.models.Movies.Suggest(ctx, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	m.caches.suggestions.Set(key, slices.Clone(suggestions))
+
+	return suggestions, nil
+}
+
+type cachedPermissionModel struct {
+	models Models
+	caches *modelCaches
+}
+
+func (m cachedPermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	return m.models.Permissions.GetAll(ctx)
+}
+
+func (m cachedPermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	if permissions, found := m.caches.permissions.Get(userID); found {
+		return permissions, nil
+	}
+
+	permissions, err := m.models.Permissions.GetAllForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.caches.permissions.Set(userID, permissions)
+
+	return permissions, nil
+}
+
+func (m cachedPermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	defer m.caches.permissions.Delete(userID)
+
+	return m.models.Permissions.AddForUser(ctx, userID, codes...)
+}
+
+func (m cachedPermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	defer m.caches.permissions.Delete(userID)
+
+	return m.models.Permissions.RemoveForUser(ctx, userID, codes...)
+}
+
+type cachedRoleModel struct {
+	models Models
+	caches *modelCaches
+}
+
+func (m cachedRoleModel) GetAll(ctx context.Context) ([]*Role, error) {
+	return m.models.Roles.GetAll(ctx)
+}
+
+func (m cachedRoleModel) GetAllForUser(ctx context.Context, userID int64) ([]*Role, error) {
+	return m.models.Roles.GetAllForUser(ctx, userID)
+}
+
+func (m cachedRoleModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	defer m.caches.permissions.Delete(userID)
+
+	return m.models.Roles.AddForUser(ctx, userID, codes...)
+}
+
+func (m cachedRoleModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	defer m.caches.permissions.Delete(userID)
+
+	return m.models.Roles.RemoveForUser(ctx, userID, codes...)
+}
+
+type cachedTokenModel struct {
+	models Models
+	caches *modelCaches
+}
+
+func (m cachedTokenModel) New(ctx context.Context, userId int64, ttl time.Duration, scope string) (*Token, error) {
+	return m.models.Tokens.New(ctx, userId, ttl, scope)
+}
+
+func (m cachedTokenModel) NewInFamily(ctx context.Context, userId int64, ttl time.Duration, scope, family string) (*Token, error) {
+	return m.models.Tokens.NewInFamily(ctx, userId, ttl, scope, family)
+}
+
+func (m cachedTokenModel) Insert(ctx context.Context, token *Token) error {
+	return m.models.Tokens.Insert(ctx, token)
+}
+
+func (m cachedTokenModel) GetByPlaintext(ctx context.Context, tokenScope, tokenPlaintext string) (*Token, error) {
+	return m.models.Tokens.GetByPlaintext(ctx, tokenScope, tokenPlaintext)
+}
+
+func (m cachedTokenModel) GetAllForUser(ctx context.Context, userId int64) ([]*Token, error) {
+	return m.models.Tokens.GetAllForUser(ctx, userId)
+}
+
+func (m cachedTokenModel) MarkUsed(ctx context.Context, token *Token) error {
+	return m.models.Tokens.MarkUsed(ctx, token)
+}
+
+func (m cachedTokenModel) DeleteAllForUser(ctx context.Context, userId int64, scope string) error {
+	defer m.caches.invalidateUser(userId)
+
+	return m.models.Tokens.DeleteAllForUser(ctx, userId, scope)
+}
+
+func (m cachedTokenModel) Delete(ctx context.Context, tokenScope, tokenPlaintext string) error {
+	defer m.caches.users.Delete(tokenCacheKey{scope: tokenScope, hash: sha256.Sum256([]byte(tokenPlaintext))})
+
+	return m.models.Tokens.Delete(ctx, tokenScope, tokenPlaintext)
+}
+
+func (m cachedTokenModel) DeleteFamily(ctx context.Context, userId int64, family string) error {
+	defer m.caches.invalidateUser(userId)
+
+	return m.models.Tokens.DeleteFamily(ctx, userId, family)
+}
+
+type cachedUserModel struct {
+	models Models
+	caches *modelCaches
+}
+
+func (m cachedUserModel) Insert(ctx context.Context, user *User) error {
+	return m.models.Users.Insert(ctx, user)
+}
+
+func (m cachedUserModel) Get(ctx context.Context, id int64) (*User, error) {
+	return m.models.Users.Get(ctx, id)
+}
+
+func (m cachedUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	return m.models.Users.GetByEmail(ctx, email)
+}
+
+func (m cachedUserModel) Update(ctx context.Context, user *User) error {
+	defer m.caches.invalidateUser(user.ID)
+
+	return m.models.Users.Update(ctx, user)
+}
+
+func (m cachedUserModel) SoftDelete(ctx context.Context, user *User) error {
+	defer m.caches.invalidateUser(user.ID)
+
+	return m.models.Users.SoftDelete(ctx, user)
+}
+
+func (m cachedUserModel) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	return m.models.Users.DeleteExpired(ctx, before)
+}
+
+func (m cachedUserModel) GetForIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	return m.models.Users.GetForIdentity(ctx, issuer, subject)
+}
+
+func (m cachedUserModel) GetTokenVersion(ctx context.Context, userID int64) (int, error) {
+	if version, found := m.caches.tokenVersions.Get(userID); found {
+		return version, nil
+	}
+
+	version, err := m.models.Users.GetTokenVersion(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	m.caches.tokenVersions.Set(userID, version)
+
+	return version, nil
+}
+
+func (m cachedUserModel) IncrementTokenVersion(ctx context.Context, userID int64) error {
+	defer m.caches.invalidateUser(userID)
+
+	return m.models.Users.IncrementTokenVersion(ctx, userID)
+}
+
+// GetForToken only caches authentication tokens. Other scopes are single use
+// and looked up rarely, so caching them would only delay their revocation.
+func (m cachedUserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	if tokenScope != ScopeAuthentication {
+		return m.models.Users.GetForToken(ctx, tokenScope, tokenPlaintext)
+	}
+
+	key := tokenCacheKey{scope: tokenScope, hash: sha256.Sum256([]byte(tokenPlaintext))}
+
+	// Entries are stored and returned by value so that handlers modifying the
+	// user they were given can't alter the cached copy.
+	if user, found := m.caches.users.Get(key); found {
+		return &user, nil
+	}
+
+	user, err := m.models.Users.GetForToken(ctx, tokenScope, tokenPlaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	m.caches.users.Set(key, *user)
+
+	return user, nil
+}
@@ -0,0 +1,195 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTTLCache(t *testing.T) {
+	t.Run("Expiry", func(t *testing.T) {
+		c := newTTLCache[int, string]("test", 10*time.Millisecond, 10)
+		c.Set(1, "one")
+
+		if v, found := c.Get(1); !found || v != "one" {
+			t.Fatalf("expected cached value 'one', got %q (found %v)", v, found)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+
+		if _, found := c.Get(1); found {
+			t.Error("expected entry to have expired")
+		}
+	})
+
+	t.Run("Size bound", func(t *testing.T) {
+		c := newTTLCache[int, string]("test", time.Minute, 2)
+		c.Set(1, "one")
+		c.Set(2, "two")
+		c.Get(1)
+		c.Set(3, "three")
+
+		if c.Len() != 2 {
+			t.Errorf("expected 2 entries, got %d", c.Len())
+		}
+		if _, found := c.Get(2); found {
+			t.Error("expected least recently used entry to be evicted")
+		}
+		if _, found := c.Get(1); !found {
+			t.Error("expected recently used entry to be kept")
+		}
+	})
+
+	t.Run("DeleteFunc", func(t *testing.T) {
+		c := newTTLCache[int, string]("test", time.Minute, 10)
+		c.Set(1, "odd")
+		c.Set(2, "even")
+		c.Set(3, "odd")
+
+		c.DeleteFunc(func(_ int, v string) bool { return v == "odd" })
+
+		if c.Len() != 1 {
+			t.Errorf("expected 1 entry, got %d", c.Len())
+		}
+	})
+}
+
+type countingPermissionModel struct {
+	MockPermissionModel
+	calls *int
+}
+
+func (m countingPermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	*m.calls++
+	return m.MockPermissionModel.GetAllForUser(ctx, userID)
+}
+
+type countingUserModel struct {
+	MockUserModel
+	calls *int
+}
+
+func (m countingUserModel) GetForToken(ctx context.Context, tokenScope string, tokenPlaintext string) (*User, error) {
+	*m.calls++
+	return m.MockUserModel.GetForToken(ctx, tokenScope, tokenPlaintext)
+}
+
+func (m countingUserModel) GetTokenVersion(ctx context.Context, userID int64) (int, error) {
+	*m.calls++
+	return m.MockUserModel.GetTokenVersion(ctx, userID)
+}
+
+type countingMovieModel struct {
+	MockMovieModel
+	calls *int
+}
+
+func (m countingMovieModel) Suggest(ctx context.Context, prefix string, limit int) ([]Suggestion, error) {
+	*m.calls++
+	return m.MockMovieModel.Suggest(ctx, prefix, limit)
+}
+
+func TestCachedModels(t *testing.T) {
+	var movieCalls, permissionCalls, userCalls int
+
+	models := NewMockModels()
+	models.Movies = countingMovieModel{calls: &movieCalls}
+	models.Permissions = countingPermissionModel{calls: &permissionCalls}
+	models.Users = countingUserModel{calls: &userCalls}
+
+	cached := NewCachedModels(models, time.Minute, 100)
+	ctx := context.Background()
+
+	t.Run("Permissions", func(t *testing.T) {
+		cached.Permissions.GetAllForUser(ctx, 1)
+		cached.Permissions.GetAllForUser(ctx, 1)
+
+		if permissionCalls != 1 {
+			t.Fatalf("expected 1 underlying call, got %d", permissionCalls)
+		}
+
+		cached.Roles.AddForUser(ctx, 1, "editor")
+		cached.Permissions.GetAllForUser(ctx, 1)
+
+		if permissionCalls != 2 {
+			t.Errorf("expected role change to invalidate the cache, got %d calls", permissionCalls)
+		}
+	})
+
+	t.Run("Suggestions", func(t *testing.T) {
+		suggestions, _ := cached.Movies.Suggest(ctx, "test", 10)
+		suggestions[0].Title = "Changed"
+
+		cachedSuggestions, _ := cached.Movies.Suggest(ctx, "test", 10)
+
+		if movieCalls != 1 {
+			t.Fatalf("expected 1 underlying call, got %d", movieCalls)
+		}
+		if cachedSuggestions[0].Title == "Changed" {
+			t.Error("expected cached suggestions to be isolated from callers")
+		}
+
+		cached.Movies.Suggest(ctx, "test", 5)
+
+		if movieCalls != 2 {
+			t.Errorf("expected a different limit to miss the cache, got %d calls", movieCalls)
+		}
+
+		cached.Movies.Update(ctx, &Movie{ID: 1, Title: "Renamed"})
+		cached.Movies.Suggest(ctx, "test", 10)
+
+		if movieCalls != 3 {
+			t.Errorf("expected a movie update to invalidate the cache, got %d calls", movieCalls)
+		}
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		user, _ := cached.Users.GetForToken(ctx, ScopeAuthentication, "token26charslong1234567890")
+		user.Name = "Changed"
+
+		cachedUser, _ := cached.Users.GetForToken(ctx, ScopeAuthentication, "token26charslong1234567890")
+
+		if userCalls != 1 {
+			t.Fatalf("expected 1 underlying call, got %d", userCalls)
+		}
+		if cachedUser.Name == "Changed" {
+			t.Error("expected cached user to be isolated from callers")
+		}
+
+		cached.Tokens.DeleteAllForUser(ctx, 1, ScopeAuthentication)
+		cached.Users.GetForToken(ctx, ScopeAuthentication, "token26charslong1234567890")
+
+		if userCalls != 2 {
+			t.Errorf("expected token revocation to invalidate the cache, got %d calls", userCalls)
+		}
+	})
+
+	t.Run("Uncached scopes", func(t *testing.T) {
+		before := userCalls
+
+		cached.Users.GetForToken(ctx, ScopeActivation, "token26charslong1234567890")
+		cached.Users.GetForToken(ctx, ScopeActivation, "token26charslong1234567890")
+
+		if userCalls-before != 2 {
+			t.Errorf("expected activation lookups to bypass the cache, got %d calls", userCalls-before)
+		}
+	})
+
+	t.Run("Token versions", func(t *testing.T) {
+		before := userCalls
+
+		cached.Users.GetTokenVersion(ctx, 1)
+		cached.Users.GetTokenVersion(ctx, 1)
+
+		if userCalls-before != 1 {
+			t.Fatalf("expected 1 underlying call, got %d", userCalls-before)
+		}
+
+		cached.Users.IncrementTokenVersion(ctx, 1)
+		cached.Users.GetTokenVersion(ctx, 1)
+
+		if userCalls-before != 2 {
+			t.Errorf("expected incrementing the token version to invalidate the cache, got %d calls", userCalls-before)
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks a position in a sorted listing for keyset pagination: the sort
+// key and id of the row the next page starts after. A cursor with Before set
+// pages backwards, ending just before that row. Scope identifies the filters
+// of the listing it was issued for.
+type Cursor struct {
+	Sort   string `json:"s"`
+	Scope  string `json:"q,omitzero"`
+	Value  string `json:"v"`
+	ID     int64  `json:"id"`
+	Before bool   `json:"b,omitzero"`
+}
+
+// Encode returns the cursor as an opaque string, signed with key so that
+// clients cannot forge positions.
+func (c Cursor) Encode(key []byte) string {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(cursorMAC(key, payload))
+}
+
+// DecodeCursor verifies and decodes a string returned by Cursor.Encode. It
+// returns ErrInvalidCursor if s is malformed or was not signed with key.
+func DecodeCursor(key []byte, s string) (Cursor, error) {
+	encodedPayload, encodedMAC, found := strings.Cut(s, ".")
+	if !found {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, cursorMAC(key, payload)) {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	var c Cursor
+
+	err = json.Unmarshal(payload, &c)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+func cursorMAC(key, payload []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(payload)
+
+	return h.Sum(nil)
+}
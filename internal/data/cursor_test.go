@@ -0,0 +1,117 @@
+package data
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/recchia/greenlight/internal/validator"
+)
+
+func TestCursor(t *testing.T) {
+	key := []byte("test-cursor-key")
+	cursor := Cursor{Sort: "-year", Value: "2024", ID: 42, Before: true}
+
+	encoded := cursor.Encode(key)
+
+	t.Run("Round trip", func(t *testing.T) {
+		got, err := DecodeCursor(key, encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != cursor {
+			t.Errorf("expected %+v, got %+v", cursor, got)
+		}
+	})
+
+	tampered := Cursor{Sort: "-year", Value: "2024", ID: 43, Before: true}.Encode(key)
+	_, signature, _ := strings.Cut(encoded, ".")
+	payload, _, _ := strings.Cut(tampered, ".")
+
+	tests := []struct {
+		name   string
+		key    []byte
+		cursor string
+	}{
+		{"Wrong key", []byte("another-key"), encoded},
+		{"Forged payload", key, payload + "." + signature},
+		{"Missing signature", key, payload},
+		{"Not base64", key, "!!!.!!!"},
+		{"Empty", key, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeCursor(tt.key, tt.cursor)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("expected ErrInvalidCursor, got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateFiltersCursor(t *testing.T) {
+	key := []byte("test-cursor-key")
+
+	newFilters := func(cursor string, page int) Filters {
+		return Filters{
+			Page:         page,
+			PageSize:     20,
+			Sort:         "title",
+			SortSafelist: []string{"id", "title", "-id", "-title"},
+			Cursor:       cursor,
+			CursorKey:    key,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		filters Filters
+		wantErr string
+	}{
+		{"Valid cursor", newFilters(Cursor{Sort: "title", Value: "Alien", ID: 3}.Encode(key), 1), ""},
+		{"Forged cursor", newFilters(Cursor{Sort: "title", Value: "Alien", ID: 3}.Encode([]byte("other")), 1), "cursor"},
+		{"Different sort", newFilters(Cursor{Sort: "-title", Value: "Alien", ID: 3}.Encode(key), 1), "cursor"},
+		{"Different filters", newFilters(Cursor{Sort: "title", Scope: "other", Value: "Alien", ID: 3}.Encode(key), 1), "cursor"},
+		{"Cursor with page", newFilters(Cursor{Sort: "title", Value: "Alien", ID: 3}.Encode(key), 2), "page"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateFilters(v, tt.filters)
+
+			if tt.wantErr == "" && !v.Valid() {
+				t.Errorf("expected valid filters, got errors: %v", v.Errors)
+			}
+			if _, ok := v.Errors[tt.wantErr]; tt.wantErr != "" && !ok {
+				t.Errorf("expected an error for %q, got %v", tt.wantErr, v.Errors)
+			}
+		})
+	}
+}
+
+func TestKeyset(t *testing.T) {
+	tests := []struct {
+		sort        string
+		before      bool
+		wantSeek    string
+		wantOrderBy string
+	}{
+		{"year", false, "(year, id) > ($1, $2)", "year ASC, id ASC"},
+		{"-year", false, "(year < $1 OR (year = $1 AND id > $2))", "year DESC, id ASC"},
+		{"year", true, "(year, id) < ($1, $2)", "year DESC, id DESC"},
+		{"-year", true, "(year > $1 OR (year = $1 AND id < $2))", "year ASC, id DESC"},
+		{"relevance", false, "(relevance < $1 OR (relevance = $1 AND id > $2))", "relevance DESC, id ASC"},
+		{"relevance", true, "(relevance > $1 OR (relevance = $1 AND id < $2))", "relevance ASC, id DESC"},
+	}
+
+	for _, tt := range tests {
+		f := Filters{Sort: tt.sort, SortSafelist: []string{"year", "-year", "relevance"}}
+
+		seek, orderBy := f.keyset(tt.before, "$1", "$2")
+		if seek != tt.wantSeek || orderBy != tt.wantOrderBy {
+			t.Errorf("keyset(%q, %t): expected %q %q, got %q %q", tt.sort, tt.before, tt.wantSeek, tt.wantOrderBy, seek, orderBy)
+		}
+	}
+}
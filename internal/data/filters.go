@@ -1,6 +1,7 @@
 package data
 
 import (
+	"fmt"
 	"slices"
 	"strings"
 
@@ -12,6 +13,13 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// Cursor, when set, selects keyset pagination instead of Page: it is a
+	// next_cursor or prev_cursor from an earlier response, signed with
+	// CursorKey. It is only accepted for the listing identified by
+	// CursorScope.
+	Cursor      string
+	CursorKey   []byte
+	CursorScope string
 }
 
 func (f Filters) sortColumn() string {
@@ -27,9 +35,36 @@ func (f Filters) sortDirection() string {
 		return "DESC"
 	}
 
+	// Relevance only makes sense best match first.
+	if f.Sort == "relevance" {
+		return "DESC"
+	}
+
 	return "ASC"
 }
 
+// keyset returns the predicate and ORDER BY clause that continue the listing
+// after (or, with before, end just before) the row whose sort value and id are
+// bound to the value and id placeholders. Ties on the sort column are broken
+// by ascending id, as in page mode. When the sort column and id run the same
+// way the predicate is a row comparison, which a (column, id) index can seek
+// to; descending sorts mix directions and need the expanded form.
+func (f Filters) keyset(before bool, value, id string) (seek, orderBy string) {
+	column := f.sortColumn()
+	ascending := f.sortDirection() == "ASC"
+
+	switch {
+	case !before && ascending:
+		return fmt.Sprintf("(%s, id) > (%s, %s)", column, value, id), column + " ASC, id ASC"
+	case !before:
+		return fmt.Sprintf("(%[1]s < %[2]s OR (%[1]s = %[2]s AND id > %[3]s))", column, value, id), column + " DESC, id ASC"
+	case ascending:
+		return fmt.Sprintf("(%s, id) < (%s, %s)", column, value, id), column + " DESC, id DESC"
+	default:
+		return fmt.Sprintf("(%[1]s > %[2]s OR (%[1]s = %[2]s AND id < %[3]s))", column, value, id), column + " ASC, id DESC"
+	}
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
@@ -44,14 +79,24 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 	v.Check(validator.PermittedValues(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	if f.Cursor != "" {
+		cursor, err := DecodeCursor(f.CursorKey, f.Cursor)
+		v.Check(err == nil, "cursor", "must be a cursor returned by a previous request")
+		v.Check(err != nil || cursor.Sort == f.Sort, "cursor", "was issued for a different sort order")
+		v.Check(err != nil || cursor.Scope == f.CursorScope, "cursor", "was issued for different filters")
+		v.Check(f.Page == 1, "page", "must not be used together with cursor")
+	}
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitzero"`
-	PageSize     int `json:"page_size,omitzero"`
-	FirstPage    int `json:"first_page,omitzero"`
-	LastPage     int `json:"last_page,omitzero"`
-	TotalRecords int `json:"total_records,omitzero"`
+	CurrentPage  int    `json:"current_page,omitzero"`
+	PageSize     int    `json:"page_size,omitzero"`
+	FirstPage    int    `json:"first_page,omitzero"`
+	LastPage     int    `json:"last_page,omitzero"`
+	TotalRecords int    `json:"total_records,omitzero"`
+	NextCursor   string `json:"next_cursor,omitzero"`
+	PrevCursor   string `json:"prev_cursor,omitzero"`
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -0,0 +1,107 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Identity links an account at an external OpenID Connect provider, known by
+// its issuer and subject, to a local user.
+type Identity struct {
+	Issuer    string
+	Subject   string
+	UserID    int64
+	CreatedAt time.Time
+}
+
+type IdentityModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Insert links the identity to its user. Linking an identity that already
+// exists is a no-op.
+func (m IdentityModel) Insert(ctx context.Context, identity *Identity) error {
+	ctx, span := startSpan(ctx, "IdentityModel.Insert")
+	defer span.End()
+
+	query := `INSERT INTO users_identities (issuer, subject, user_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, identity.Issuer, identity.Subject, identity.UserID)
+
+	return err
+}
+
+// OIDCLogin holds what is needed to finish an authorization code login once
+// the provider redirects back with the state it was started with.
+type OIDCLogin struct {
+	State        string
+	CodeVerifier string
+	Nonce        string
+	Expiry       time.Time
+}
+
+type OIDCLoginModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Insert stores a pending login, clearing out any that were abandoned.
+func (m OIDCLoginModel) Insert(ctx context.Context, login *OIDCLogin) error {
+	ctx, span := startSpan(ctx, "OIDCLoginModel.Insert")
+	defer span.End()
+
+	stateHash := sha256.Sum256([]byte(login.State))
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expiry < NOW()`)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO oidc_logins (state_hash, code_verifier, nonce, expiry) VALUES ($1, $2, $3, $4)`
+
+	_, err = m.DB.ExecContext(ctx, query, stateHash[:], login.CodeVerifier, login.Nonce, login.Expiry)
+
+	return err
+}
+
+// Take removes and returns the pending login started with state, so that
+// each state can only be used once.
+func (m OIDCLoginModel) Take(ctx context.Context, state string) (*OIDCLogin, error) {
+	ctx, span := startSpan(ctx, "OIDCLoginModel.Take")
+	defer span.End()
+
+	stateHash := sha256.Sum256([]byte(state))
+
+	query := `DELETE FROM oidc_logins
+		WHERE state_hash = $1 AND expiry > NOW()
+		RETURNING code_verifier, nonce, expiry`
+
+	login := OIDCLogin{State: state}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, stateHash[:]).Scan(&login.CodeVerifier, &login.Nonce, &login.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &login, nil
+}
@@ -0,0 +1,146 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginAttempt tracks consecutive failed logins for a key, such as an email
+// address or a client IP.
+type LoginAttempt struct {
+	Key           string
+	Failures      int
+	LockedUntil   time.Time
+	LastFailureAt time.Time
+}
+
+func (a *LoginAttempt) IsLocked() bool {
+	return time.Now().Before(a.LockedUntil)
+}
+
+func (a *LoginAttempt) RetryAfter() time.Duration {
+	return time.Until(a.LockedUntil)
+}
+
+// LockoutPolicy describes how a key gets locked out. Once Threshold
+// consecutive failures have been recorded, every further failure locks the
+// key for BaseDelay, doubling each time up to MaxDelay. Failures older than
+// Window are forgotten.
+type LockoutPolicy struct {
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Window    time.Duration
+}
+
+func (p LockoutPolicy) LockDuration(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.Threshold; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, p.MaxDelay)
+}
+
+type LoginAttemptModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (m LoginAttemptModel) Get(ctx context.Context, key string) (*LoginAttempt, error) {
+	ctx, span := startSpan(ctx, "LoginAttemptModel.Get")
+	defer span.End()
+
+	query := `SELECT key, failures, COALESCE(locked_until, 'epoch'), last_failure_at
+		FROM login_attempts
+		WHERE key = $1`
+
+	var attempt LoginAttempt
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(
+		&attempt.Key,
+		&attempt.Failures,
+		&attempt.LockedUntil,
+		&attempt.LastFailureAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &attempt, nil
+}
+
+// RecordFailure increments the failure counter for key, applying the lockout
+// policy to the new count.
+func (m LoginAttemptModel) RecordFailure(ctx context.Context, key string, policy LockoutPolicy) (*LoginAttempt, error) {
+	ctx, span := startSpan(ctx, "LoginAttemptModel.RecordFailure")
+	defer span.End()
+
+	query := `
+		INSERT INTO login_attempts (key, failures, last_failure_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE
+				WHEN login_attempts.last_failure_at < NOW() - make_interval(secs => $2) THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failure_at = NOW()
+		RETURNING key, failures, COALESCE(locked_until, 'epoch'), last_failure_at`
+
+	var attempt LoginAttempt
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key, policy.Window.Seconds()).Scan(
+		&attempt.Key,
+		&attempt.Failures,
+		&attempt.LockedUntil,
+		&attempt.LastFailureAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := policy.LockDuration(attempt.Failures)
+	if lock == 0 {
+		return &attempt, nil
+	}
+
+	attempt.LockedUntil = time.Now().Add(lock)
+
+	query = `UPDATE login_attempts SET locked_until = $2 WHERE key = $1`
+
+	_, err = m.DB.ExecContext(ctx, query, key, attempt.LockedUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+func (m LoginAttemptModel) Reset(ctx context.Context, key string) error {
+	ctx, span := startSpan(ctx, "LoginAttemptModel.Reset")
+	defer span.End()
+
+	query := `DELETE FROM login_attempts WHERE key = $1`
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+
+	return err
+}
@@ -0,0 +1,34 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutPolicyLockDuration(t *testing.T) {
+	policy := LockoutPolicy{
+		Threshold: 3,
+		BaseDelay: time.Minute,
+		MaxDelay:  10 * time.Minute,
+	}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 8 * time.Minute},
+		{7, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := policy.LockDuration(tt.failures); got != tt.want {
+			t.Errorf("LockDuration(%d): expected %v, got %v", tt.failures, tt.want, got)
+		}
+	}
+}
@@ -1,15 +1,104 @@
 package data
 
 import (
+	"context"
+	"strings"
 	"time"
 )
 
+type MockAPIKeyModel struct{}
+
+func (m MockAPIKeyModel) New(ctx context.Context, userID int64, name string, permissions Permissions) (*APIKey, error) {
+	key := generateAPIKey(userID, name, permissions)
+	key.ID = 2
+	key.CreatedAt = time.Now()
+	key.Version = 1
+	return key, nil
+}
+func (m MockAPIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	return nil
+}
+func (m MockAPIKeyModel) Get(ctx context.Context, id, userID int64) (*APIKey, error) {
+	if id != 1 || userID != 1 {
+		return nil, ErrRecordNotFound
+	}
+	return &APIKey{
+		ID:          1,
+		UserID:      1,
+		Name:        "Importer",
+		Permissions: Permissions{"movies:read", "movies:write"},
+		CreatedAt:   time.Now(),
+		Version:     1,
+	}, nil
+}
+func (m MockAPIKeyModel) GetByPlaintext(ctx context.Context, plaintext string) (*APIKey, error) {
+	if plaintext != "apikey26charslong123456789" {
+		return nil, ErrRecordNotFound
+	}
+	key, _ := m.Get(ctx, 1, 1)
+	key.Permissions = Permissions{"movies:read"}
+	return key, nil
+}
+func (m MockAPIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	key, err := m.Get(ctx, 1, userID)
+	if err != nil {
+		return []*APIKey{}, nil
+	}
+	return []*APIKey{key}, nil
+}
+func (m MockAPIKeyModel) Update(ctx context.Context, key *APIKey) error {
+	key.Version++
+	return nil
+}
+func (m MockAPIKeyModel) MarkUsed(ctx context.Context, key *APIKey) error {
+	return nil
+}
+func (m MockAPIKeyModel) Delete(ctx context.Context, id, userID int64) error {
+	if id != 1 || userID != 1 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+type MockIdentityModel struct{}
+
+func (m MockIdentityModel) Insert(ctx context.Context, identity *Identity) error {
+	return nil
+}
+
+type MockLoginAttemptModel struct{}
+
+func (m MockLoginAttemptModel) Get(ctx context.Context, key string) (*LoginAttempt, error) {
+	if key == "email:locked@example.com" {
+		return &LoginAttempt{
+			Key:           key,
+			Failures:      5,
+			LockedUntil:   time.Now().Add(time.Minute),
+			LastFailureAt: time.Now(),
+		}, nil
+	}
+	return nil, ErrRecordNotFound
+}
+func (m MockLoginAttemptModel) RecordFailure(ctx context.Context, key string, policy LockoutPolicy) (*LoginAttempt, error) {
+	return &LoginAttempt{Key: key, Failures: 1, LastFailureAt: time.Now()}, nil
+}
+func (m MockLoginAttemptModel) Reset(ctx context.Context, key string) error {
+	return nil
+}
+
 type MockMovieModel struct{}
 
-func (m MockMovieModel) Insert(movie *Movie) error {
+func (m MockMovieModel) Insert(ctx context.Context, movie *Movie) error {
+	return nil
+}
+func (m MockMovieModel) InsertMany(ctx context.Context, movies []*Movie) error {
+	for i, movie := range movies {
+		movie.ID = int64(i + 1)
+		movie.Version = 1
+	}
 	return nil
 }
-func (m MockMovieModel) Get(id int64) (*Movie, error) {
+func (m MockMovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -22,28 +111,83 @@ func (m MockMovieModel) Get(id int64) (*Movie, error) {
 		Version: 1,
 	}, nil
 }
-func (m MockMovieModel) Update(movie *Movie) error {
+func (m MockMovieModel) Update(ctx context.Context, movie *Movie) error {
 	return nil
 }
-func (m MockMovieModel) Delete(id int64) error {
+func (m MockMovieModel) Delete(ctx context.Context, id int64) error {
 	return nil
 }
-func (m MockMovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m MockMovieModel) GetAll(ctx context.Context, criteria MovieCriteria, filters Filters) ([]*Movie, Metadata, error) {
 	return nil, Metadata{}, nil
 }
+func (m MockMovieModel) Suggest(ctx context.Context, prefix string, limit int) ([]Suggestion, error) {
+	if !strings.HasPrefix("test movie", prefix) {
+		return []Suggestion{}, nil
+	}
+	return []Suggestion{{ID: 1, Title: "Test Movie"}}, nil
+}
+
+// MockOIDCCodeVerifier and MockOIDCNonce are returned for every pending
+// OIDC login, except one started with the state "expired".
+const (
+	MockOIDCCodeVerifier = "mock-code-verifier-0123456789abcdefghijklmnopqrstuvwxyz"
+	MockOIDCNonce        = "mock-nonce"
+)
+
+type MockOIDCLoginModel struct{}
+
+func (m MockOIDCLoginModel) Insert(ctx context.Context, login *OIDCLogin) error {
+	return nil
+}
+func (m MockOIDCLoginModel) Take(ctx context.Context, state string) (*OIDCLogin, error) {
+	if state == "expired" {
+		return nil, ErrRecordNotFound
+	}
+	return &OIDCLogin{
+		State:        state,
+		CodeVerifier: MockOIDCCodeVerifier,
+		Nonce:        MockOIDCNonce,
+		Expiry:       time.Now().Add(time.Minute),
+	}, nil
+}
 
 type MockPermissionModel struct{}
 
-func (m MockPermissionModel) GetAllForUser(userID int64) (Permissions, error) {
-	return Permissions{"movies:read", "movies:write"}, nil
+func (m MockPermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	return Permissions{"movies:read", "movies:write", "users:admin"}, nil
 }
-func (m MockPermissionModel) AddForUser(userID int64, codes ...string) error {
+func (m MockPermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	return Permissions{"movies:read", "movies:write", "users:admin"}, nil
+}
+func (m MockPermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	return nil
+}
+func (m MockPermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	return nil
+}
+
+type MockRoleModel struct{}
+
+func (m MockRoleModel) GetAll(ctx context.Context) ([]*Role, error) {
+	return []*Role{
+		{Code: "admin", Permissions: Permissions{"movies:read", "movies:write", "users:admin"}},
+		{Code: "editor", Permissions: Permissions{"movies:read", "movies:write"}},
+		{Code: "viewer", Permissions: Permissions{"movies:read"}},
+	}, nil
+}
+func (m MockRoleModel) GetAllForUser(ctx context.Context, userID int64) ([]*Role, error) {
+	return []*Role{{Code: "viewer", Permissions: Permissions{"movies:read"}}}, nil
+}
+func (m MockRoleModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	return nil
+}
+func (m MockRoleModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
 	return nil
 }
 
 type MockTokenModel struct{}
 
-func (m MockTokenModel) New(userId int64, ttl time.Duration, scope string) (*Token, error) {
+func (m MockTokenModel) New(ctx context.Context, userId int64, ttl time.Duration, scope string) (*Token, error) {
 	return &Token{
 		Plaintext: "token26charslong1234567890",
 		UserID:    userId,
@@ -51,52 +195,210 @@ func (m MockTokenModel) New(userId int64, ttl time.Duration, scope string) (*Tok
 		Scope:     scope,
 	}, nil
 }
-func (m MockTokenModel) Insert(token *Token) error {
+func (m MockTokenModel) NewInFamily(ctx context.Context, userId int64, ttl time.Duration, scope, family string) (*Token, error) {
+	token, err := m.New(ctx, userId, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	token.Family = family
+	return token, nil
+}
+func (m MockTokenModel) Insert(ctx context.Context, token *Token) error {
+	return nil
+}
+func (m MockTokenModel) GetByPlaintext(ctx context.Context, tokenScope, tokenPlaintext string) (*Token, error) {
+	return &Token{
+		Plaintext: tokenPlaintext,
+		UserID:    1,
+		Expiry:    time.Now().Add(time.Hour),
+		Scope:     tokenScope,
+		Family:    "family",
+		Used:      tokenPlaintext == "usedrefreshtoken1234567890",
+	}, nil
+}
+func (m MockTokenModel) GetAllForUser(ctx context.Context, userId int64) ([]*Token, error) {
+	return []*Token{{UserID: userId, Expiry: time.Now().Add(time.Hour), Scope: ScopeAuthentication}}, nil
+}
+func (m MockTokenModel) MarkUsed(ctx context.Context, token *Token) error {
+	token.Used = true
 	return nil
 }
-func (m MockTokenModel) DeleteAllForUser(userId int64, scope string) error {
+func (m MockTokenModel) DeleteAllForUser(ctx context.Context, userId int64, scope string) error {
 	return nil
 }
+func (m MockTokenModel) Delete(ctx context.Context, tokenScope, tokenPlaintext string) error {
+	return nil
+}
+func (m MockTokenModel) DeleteFamily(ctx context.Context, userId int64, family string) error {
+	return nil
+}
+
+// mockPasswordHash is a bcrypt hash of "pa$$word123" at the minimum cost.
+var mockPasswordHash = []byte("$2a$04$NRLqNZEbjenxibenf5TEiuWOhzNW/92FX0PsUWI2qCqpVbb9NnG.6")
+
+// MockTwoFactorSecret is the TOTP secret enabled for user 3, and
+// MockRecoveryCode is their only unused recovery code.
+const (
+	MockTwoFactorSecret = "JBSWY3DPEHPK3PXP"
+	MockRecoveryCode    = "abcde-fghij"
+)
+
+type MockTwoFactorModel struct{}
+
+func (m MockTwoFactorModel) Get(ctx context.Context, userID int64) (*TwoFactor, error) {
+	if userID != 3 {
+		return nil, ErrRecordNotFound
+	}
+	return &TwoFactor{
+		UserID:    userID,
+		Secret:    MockTwoFactorSecret,
+		CreatedAt: time.Now(),
+		Enabled:   true,
+	}, nil
+}
+func (m MockTwoFactorModel) Enrol(ctx context.Context, tf *TwoFactor) error {
+	tf.CreatedAt = time.Now()
+	return nil
+}
+func (m MockTwoFactorModel) Confirm(ctx context.Context, tf *TwoFactor, step int64, recoveryCodeHashes [][]byte) error {
+	tf.Enabled = true
+	tf.LastUsedStep = step
+	return nil
+}
+func (m MockTwoFactorModel) MarkStepUsed(ctx context.Context, tf *TwoFactor, step int64) error {
+	tf.LastUsedStep = step
+	return nil
+}
+func (m MockTwoFactorModel) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	if code != MockRecoveryCode {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+func (m MockTwoFactorModel) Delete(ctx context.Context, userID int64) error {
+	return nil
+}
+
+// MockTokenVersion is the token version of the mock users.
+const MockTokenVersion = 2
+
+const MockDeletedEmail = "deleted@example.com"
 
 type MockUserModel struct{}
 
-func (m MockUserModel) Insert(user *User) error {
+// Insert rejects MockDeletedEmail, which belongs to an account in its deletion
+// grace period: it can't be looked up but its address is still taken.
+func (m MockUserModel) Insert(ctx context.Context, user *User) error {
+	if user.Email == MockDeletedEmail {
+		return ErrDuplicateEmail
+	}
 	user.ID = 1
 	user.CreatedAt = time.Now()
 	user.Activated = false
 	return nil
 }
 
-func (m MockUserModel) GetByEmail(email string) (*User, error) {
-	if email == "test@example.com" {
+func (m MockUserModel) Get(ctx context.Context, id int64) (*User, error) {
+	if id != 1 {
+		return nil, ErrRecordNotFound
+	}
+	return &User{
+		ID:        1,
+		CreatedAt: time.Now(),
+		Name:      "Test User",
+		Email:     "test@example.com",
+		Password:  password{hash: mockPasswordHash},
+		Activated: true,
+	}, nil
+}
+
+func (m MockUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	switch email {
+	case "test@example.com":
 		return &User{
 			ID:        1,
 			CreatedAt: time.Now(),
 			Name:      "Test User",
 			Email:     "test@example.com",
+			Password:  password{hash: mockPasswordHash},
+			Activated: true,
+		}, nil
+	case "2fa@example.com":
+		return &User{
+			ID:        3,
+			CreatedAt: time.Now(),
+			Name:      "Two Factor User",
+			Email:     "2fa@example.com",
+			Password:  password{hash: mockPasswordHash},
 			Activated: true,
 		}, nil
+	case "inactive@example.com":
+		return &User{
+			ID:        2,
+			CreatedAt: time.Now(),
+			Name:      "Inactive User",
+			Email:     "inactive@example.com",
+			Password:  password{hash: mockPasswordHash},
+			Activated: false,
+		}, nil
 	}
 	return nil, ErrRecordNotFound
 }
-func (m MockUserModel) Update(user *User) error {
+func (m MockUserModel) Update(ctx context.Context, user *User) error {
 	return nil
 }
-func (m MockUserModel) GetForToken(tokenScope string, tokenPlaintext string) (*User, error) {
-	return &User{
+func (m MockUserModel) GetForToken(ctx context.Context, tokenScope string, tokenPlaintext string) (*User, error) {
+	user := &User{
 		ID:        1,
 		CreatedAt: time.Now(),
 		Name:      "Test User",
 		Email:     "test@example.com",
+		Password:  password{hash: mockPasswordHash},
 		Activated: true,
-	}, nil
+	}
+	switch tokenScope {
+	case ScopeEmailChange:
+		user.PendingEmail = "new@example.com"
+	case ScopeTwoFactor:
+		user.ID = 3
+		user.Email = "2fa@example.com"
+	}
+	return user, nil
+}
+
+func (m MockUserModel) GetForIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	if subject != "existing-subject" {
+		return nil, ErrRecordNotFound
+	}
+	return m.Get(ctx, 1)
+}
+func (m MockUserModel) GetTokenVersion(ctx context.Context, userID int64) (int, error) {
+	if userID != 1 && userID != 3 {
+		return 0, ErrRecordNotFound
+	}
+	return MockTokenVersion, nil
+}
+func (m MockUserModel) IncrementTokenVersion(ctx context.Context, userID int64) error {
+	return nil
+}
+func (m MockUserModel) SoftDelete(ctx context.Context, user *User) error {
+	return nil
+}
+func (m MockUserModel) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
 }
 
 func NewMockModels() Models {
 	return Models{
-		Movies:      MockMovieModel{},
-		Permissions: MockPermissionModel{},
-		Tokens:      MockTokenModel{},
-		Users:       MockUserModel{},
+		APIKeys:       MockAPIKeyModel{},
+		Identities:    MockIdentityModel{},
+		LoginAttempts: MockLoginAttemptModel{},
+		Movies:        MockMovieModel{},
+		OIDCLogins:    MockOIDCLoginModel{},
+		Permissions:   MockPermissionModel{},
+		Roles:         MockRoleModel{},
+		Tokens:        MockTokenModel{},
+		TwoFactor:     MockTwoFactorModel{},
+		Users:         MockUserModel{},
 	}
 }
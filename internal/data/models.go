@@ -1,9 +1,14 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -12,35 +17,106 @@ var (
 )
 
 type Models struct {
+	APIKeys interface {
+		New(ctx context.Context, userID int64, name string, permissions Permissions) (*APIKey, error)
+		Insert(ctx context.Context, key *APIKey) error
+		Get(ctx context.Context, id, userID int64) (*APIKey, error)
+		GetByPlaintext(ctx context.Context, plaintext string) (*APIKey, error)
+		GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error)
+		Update(ctx context.Context, key *APIKey) error
+		MarkUsed(ctx context.Context, key *APIKey) error
+		Delete(ctx context.Context, id, userID int64) error
+	}
+	Identities interface {
+		Insert(ctx context.Context, identity *Identity) error
+	}
+	LoginAttempts interface {
+		Get(ctx context.Context, key string) (*LoginAttempt, error)
+		RecordFailure(ctx context.Context, key string, policy LockoutPolicy) (*LoginAttempt, error)
+		Reset(ctx context.Context, key string) error
+	}
 	Movies interface {
-		Insert(movie *Movie) error
-		Get(id int64) (*Movie, error)
-		Update(movie *Movie) error
-		Delete(id int64) error
-		GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error)
+		Insert(ctx context.Context, movie *Movie) error
+		InsertMany(ctx context.Context, movies []*Movie) error
+		Get(ctx context.Context, id int64) (*Movie, error)
+		Update(ctx context.Context, movie *Movie) error
+		Delete(ctx context.Context, id int64) error
+		GetAll(ctx context.Context, criteria MovieCriteria, filters Filters) ([]*Movie, Metadata, error)
+		Suggest(ctx context.Context, prefix string, limit int) ([]Suggestion, error)
+	}
+	OIDCLogins interface {
+		Insert(ctx context.Context, login *OIDCLogin) error
+		Take(ctx context.Context, state string) (*OIDCLogin, error)
 	}
 	Permissions interface {
-		GetAllForUser(userID int64) (Permissions, error)
-		AddForUser(userID int64, codes ...string) error
+		GetAll(ctx context.Context) (Permissions, error)
+		GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+		AddForUser(ctx context.Context, userID int64, codes ...string) error
+		RemoveForUser(ctx context.Context, userID int64, codes ...string) error
+	}
+	Roles interface {
+		GetAll(ctx context.Context) ([]*Role, error)
+		GetAllForUser(ctx context.Context, userID int64) ([]*Role, error)
+		AddForUser(ctx context.Context, userID int64, codes ...string) error
+		RemoveForUser(ctx context.Context, userID int64, codes ...string) error
 	}
 	Tokens interface {
-		New(userId int64, ttl time.Duration, scope string) (*Token, error)
-		Insert(token *Token) error
-		DeleteAllForUser(userId int64, scope string) error
+		New(ctx context.Context, userId int64, ttl time.Duration, scope string) (*Token, error)
+		NewInFamily(ctx context.Context, userId int64, ttl time.Duration, scope, family string) (*Token, error)
+		Insert(ctx context.Context, token *Token) error
+		GetByPlaintext(ctx context.Context, tokenScope, tokenPlaintext string) (*Token, error)
+		GetAllForUser(ctx context.Context, userId int64) ([]*Token, error)
+		MarkUsed(ctx context.Context, token *Token) error
+		DeleteAllForUser(ctx context.Context, userId int64, scope string) error
+		Delete(ctx context.Context, tokenScope, tokenPlaintext string) error
+		DeleteFamily(ctx context.Context, userId int64, family string) error
+	}
+	TwoFactor interface {
+		Get(ctx context.Context, userID int64) (*TwoFactor, error)
+		Enrol(ctx context.Context, tf *TwoFactor) error
+		Confirm(ctx context.Context, tf *TwoFactor, step int64, recoveryCodeHashes [][]byte) error
+		MarkStepUsed(ctx context.Context, tf *TwoFactor, step int64) error
+		UseRecoveryCode(ctx context.Context, userID int64, code string) error
+		Delete(ctx context.Context, userID int64) error
 	}
 	Users interface {
-		Insert(user *User) error
-		GetByEmail(email string) (*User, error)
-		Update(user *User) error
-		GetForToken(tokenScope string, tokenPlaintext string) (*User, error)
+		Insert(ctx context.Context, user *User) error
+		Get(ctx context.Context, id int64) (*User, error)
+		GetByEmail(ctx context.Context, email string) (*User, error)
+		Update(ctx context.Context, user *User) error
+		GetForToken(ctx context.Context, tokenScope string, tokenPlaintext string) (*User, error)
+		GetForIdentity(ctx context.Context, issuer, subject string) (*User, error)
+		GetTokenVersion(ctx context.Context, userID int64) (int, error)
+		IncrementTokenVersion(ctx context.Context, userID int64) error
+		SoftDelete(ctx context.Context, user *User) error
+		DeleteExpired(ctx context.Context, before time.Time) (int64, error)
 	}
 }
 
-func NewModels(db *sql.DB) Models {
+var tracer = otel.Tracer("github.com/recchia/greenlight/internal/data")
+
+// startSpan starts a span around a model method, as a child of the span
+// carried by ctx.
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "postgresql")),
+	)
+}
+
+// NewModels returns the models backed by db. Every query is cancelled when the
+// caller's context is, or after timeout at the latest.
+func NewModels(db *sql.DB, timeout time.Duration) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		APIKeys:       APIKeyModel{DB: db, Timeout: timeout},
+		Identities:    IdentityModel{DB: db, Timeout: timeout},
+		LoginAttempts: LoginAttemptModel{DB: db, Timeout: timeout},
+		Movies:        MovieModel{DB: db, Timeout: timeout},
+		OIDCLogins:    OIDCLoginModel{DB: db, Timeout: timeout},
+		Permissions:   PermissionModel{DB: db, Timeout: timeout},
+		Roles:         RoleModel{DB: db, Timeout: timeout},
+		Tokens:        TokenModel{DB: db, Timeout: timeout},
+		TwoFactor:     TwoFactorModel{DB: db, Timeout: timeout},
+		Users:         UserModel{DB: db, Timeout: timeout},
 	}
 }
@@ -2,10 +2,17 @@ package data
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/lib/pq"
 	"github.com/recchia/greenlight/internal/validator"
@@ -19,6 +26,8 @@ type Movie struct {
 	Runtime   Runtime   `json:"runtime,omitzero"`
 	Genres    []string  `json:"genres,omitzero"`
 	Version   int32     `json:"version"`
+	// Relevance is how well the movie matched a search, set by GetAll.
+	Relevance float32 `json:"-"`
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
@@ -38,20 +47,190 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
 }
 
+// MovieCriteria selects the movies to list. Zero values and empty lists
+// leave the corresponding condition out.
+type MovieCriteria struct {
+	Title         string
+	Search        string
+	YearMin       int
+	YearMax       int
+	RuntimeMin    int
+	RuntimeMax    int
+	GenresAll     []string
+	GenresAny     []string
+	ExcludeGenres []string
+}
+
+func ValidateMovieCriteria(v *validator.Validator, c MovieCriteria) {
+	currentYear := time.Now().Year()
+
+	v.Check(len(c.Search) <= 500, "search", "must not be more than 500 bytes long")
+
+	v.Check(c.YearMin == 0 || c.YearMin >= 1888, "year_min", "must be greater than 1888")
+	v.Check(c.YearMin <= currentYear, "year_min", "must not be in the future")
+	v.Check(c.YearMax == 0 || c.YearMax >= 1888, "year_max", "must be greater than 1888")
+	v.Check(c.YearMax <= currentYear, "year_max", "must not be in the future")
+	v.Check(c.YearMin == 0 || c.YearMax == 0 || c.YearMin <= c.YearMax, "year_max", "must not be less than year_min")
+
+	v.Check(c.RuntimeMin >= 0, "runtime_min", "must be a positive number")
+	v.Check(c.RuntimeMax >= 0, "runtime_max", "must be a positive number")
+	v.Check(c.RuntimeMin == 0 || c.RuntimeMax == 0 || c.RuntimeMin <= c.RuntimeMax, "runtime_max", "must not be less than runtime_min")
+
+	for key, genres := range map[string][]string{"genres_all": c.GenresAll, "genres_any": c.GenresAny, "exclude_genres": c.ExcludeGenres} {
+		v.Check(len(genres) <= 20, key, "must not contain more than 20 genres")
+		v.Check(!slices.Contains(genres, ""), key, "must not contain empty values")
+	}
+
+	for _, genre := range c.ExcludeGenres {
+		v.Check(!slices.Contains(c.GenresAll, genre), "exclude_genres", "must not contain genres from genres_all")
+		v.Check(!slices.Contains(c.GenresAny, genre), "exclude_genres", "must not contain genres from genres_any")
+	}
+}
+
+// CursorScope identifies the movies selected by the criteria, for use as
+// Filters.CursorScope. The order of the genre lists doesn't matter.
+func (c MovieCriteria) CursorScope() string {
+	sorted := func(genres []string) []string {
+		return slices.Sorted(slices.Values(append([]string{}, genres...)))
+	}
+
+	c.GenresAll = sorted(c.GenresAll)
+	c.GenresAny = sorted(c.GenresAny)
+	c.ExcludeGenres = sorted(c.ExcludeGenres)
+
+	payload, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+
+	sum := sha256.Sum256(payload)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// movieMatches selects the movies matching a MovieCriteria, along with their
+// relevance to its search, for use as a subquery. It takes the first ten
+// query parameters, in the order returned by MovieCriteria.args, and switches
+// each condition off for a zero value instead of building the condition up
+// from strings.
+//
+// A search matches titles containing every search word, stemmed and as a
+// prefix of a title word, or titles similar enough to the search as a whole
+// to tolerate typos. Matches are ranked by the sum of both scores.
+const movieMatches = `
+	SELECT *,
+		CASE WHEN $9 = '' THEN 0::real
+		ELSE ts_rank(to_tsvector('english', title), to_tsquery('english', $10)) + word_similarity($9, title)
+		END AS relevance
+	FROM movies
+	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	AND (year >= $2 OR $2 = 0)
+	AND (year <= $3 OR $3 = 0)
+	AND (runtime >= $4 OR $4 = 0)
+	AND (runtime <= $5 OR $5 = 0)
+	AND (genres @> $6 OR $6 = '{}')
+	AND (genres && $7 OR $7 = '{}')
+	AND NOT (genres && $8)
+	AND ($9 = '' OR to_tsvector('english', title) @@ to_tsquery('english', $10) OR $9 <% title)`
+
+func (c MovieCriteria) args() []any {
+	// A nil slice would be sent as NULL, which matches nothing.
+	nonNil := func(genres []string) []string {
+		if genres == nil {
+			return []string{}
+		}
+		return genres
+	}
+
+	return []any{
+		c.Title,
+		c.YearMin,
+		c.YearMax,
+		c.RuntimeMin,
+		c.RuntimeMax,
+		pq.Array(nonNil(c.GenresAll)),
+		pq.Array(nonNil(c.GenresAny)),
+		pq.Array(nonNil(c.ExcludeGenres)),
+		c.Search,
+		prefixTSQuery(c.Search),
+	}
+}
+
+// prefixTSQuery turns a search into a tsquery source matching titles with
+// words starting with each of the search words, so that "star wa" finds
+// "Star Wars". Everything but letters and digits is dropped, which leaves no
+// tsquery operators for a search to inject.
+func prefixTSQuery(search string) string {
+	words := strings.FieldsFunc(search, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
 type MovieModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
+	ctx, span := startSpan(ctx, "MovieModel.Insert")
+	defer span.End()
+
 	query := `INSERT INTO movies (title, year, runtime, genres) VALUES ($1, $2, $3, $4) RETURNING id, created_at, version`
 	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
 }
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+// InsertMany inserts movies in a single transaction, so that either all of
+// them are stored or none is. The timeout applies to each insert rather than
+// to the whole batch, which may hold thousands of movies.
+func (m MovieModel) InsertMany(ctx context.Context, movies []*Movie) error {
+	ctx, span := startSpan(ctx, "MovieModel.InsertMany")
+	defer span.End()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO movies (title, year, runtime, genres) VALUES ($1, $2, $3, $4) RETURNING id, created_at, version`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	insert := func(movie *Movie) error {
+		ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+		defer cancel()
+
+		args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+
+		return stmt.QueryRowContext(ctx, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	}
+
+	for _, movie := range movies {
+		err = insert(movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	ctx, span := startSpan(ctx, "MovieModel.Get")
+	defer span.End()
+
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -60,7 +239,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 	var movie Movie
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(&movie.ID, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.CreatedAt, &movie.Version)
@@ -75,11 +254,14 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
+	ctx, span := startSpan(ctx, "MovieModel.Update")
+	defer span.End()
+
 	query := `UPDATE movies SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1 WHERE id = $5 AND version = $6 RETURNING version`
 	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ID, movie.Version}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
@@ -94,13 +276,16 @@ func (m MovieModel) Update(movie *Movie) error {
 	return nil
 }
 
-func (m MovieModel) Delete(id int64) error {
+func (m MovieModel) Delete(ctx context.Context, id int64) error {
+	ctx, span := startSpan(ctx, "MovieModel.Delete")
+	defer span.End()
+
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `DELETE FROM movies WHERE id = $1`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	result, err := m.DB.ExecContext(ctx, query, id)
@@ -122,17 +307,26 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// GetAll lists the movies matching criteria. It pages by
+// filters.Page unless filters.Cursor is set, in which case it continues from
+// the cursor's position instead. Either way the returned metadata carries the
+// cursors of the neighbouring pages.
+func (m MovieModel) GetAll(ctx context.Context, criteria MovieCriteria, filters Filters) ([]*Movie, Metadata, error) {
+	ctx, span := startSpan(ctx, "MovieModel.GetAll")
+	defer span.End()
+
+	if filters.Cursor != "" {
+		return m.getAllAfterCursor(ctx, criteria, filters)
+	}
+
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER (), id, title, year, runtime, genres, created_at, version 
-		FROM movies 
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') 
-		AND (genres @> $2 OR $2 = '{}') 
-		ORDER BY %s %s, id ASC LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		SELECT count(*) OVER (), id, title, year, runtime, genres, created_at, version, relevance
+		FROM (%s) AS matches
+		ORDER BY %s %s, id ASC LIMIT $11 OFFSET $12`, movieMatches, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
+	args := append(criteria.args(), filters.limit(), filters.offset())
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -146,7 +340,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	for rows.Next() {
 		var movie Movie
 
-		err := rows.Scan(&totalRecords, &movie.ID, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.CreatedAt, &movie.Version)
+		err := rows.Scan(&totalRecords, &movie.ID, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.CreatedAt, &movie.Version, &movie.Relevance)
 
 		if err != nil {
 			return nil, Metadata{}, err
@@ -161,5 +355,160 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 
+	if len(movies) > 0 {
+		if filters.offset()+len(movies) < totalRecords {
+			metadata.NextCursor = filters.movieCursor(movies[len(movies)-1], false)
+		}
+		if filters.Page > 1 {
+			metadata.PrevCursor = filters.movieCursor(movies[0], true)
+		}
+	}
+
 	return movies, metadata, nil
 }
+
+// getAllAfterCursor is the keyset half of GetAll. It seeks past the cursor's
+// row using the sort column and id instead of an offset, and does not count
+// the matching rows. Ascending sorts on title, year and runtime can be served
+// from the (column, id) indexes; descending and relevance sorts still have to
+// sort the matching rows.
+func (m MovieModel) getAllAfterCursor(ctx context.Context, criteria MovieCriteria, filters Filters) ([]*Movie, Metadata, error) {
+	cursor, err := DecodeCursor(filters.CursorKey, filters.Cursor)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	seek, orderBy := filters.keyset(cursor.Before, "$11", "$12")
+
+	query := fmt.Sprintf(`
+		SELECT id, title, year, runtime, genres, created_at, version, relevance
+		FROM (%s) AS matches
+		WHERE %s
+		ORDER BY %s LIMIT $13`, movieMatches, seek, orderBy)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	// One extra row tells whether there is a page beyond this one.
+	args := append(criteria.args(), cursor.Value, cursor.ID, filters.limit()+1)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(&movie.ID, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.CreatedAt, &movie.Version, &movie.Relevance)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	hasMore := len(movies) > filters.limit()
+	if hasMore {
+		movies = movies[:filters.limit()]
+	}
+
+	// The page we came from is always on the other side of the cursor.
+	hasNext, hasPrev := hasMore, true
+	if cursor.Before {
+		slices.Reverse(movies)
+		hasNext, hasPrev = true, hasMore
+	}
+
+	metadata := Metadata{PageSize: filters.PageSize}
+
+	if len(movies) > 0 {
+		if hasNext {
+			metadata.NextCursor = filters.movieCursor(movies[len(movies)-1], false)
+		}
+		if hasPrev {
+			metadata.PrevCursor = filters.movieCursor(movies[0], true)
+		}
+	}
+
+	return movies, metadata, nil
+}
+
+// Suggestion is a movie title offered while a user is typing.
+type Suggestion struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+}
+
+// Suggest returns up to limit movies for a partially typed, lowercase title:
+// titles starting with prefix first, then titles containing words similar to
+// it, best match first.
+func (m MovieModel) Suggest(ctx context.Context, prefix string, limit int) ([]Suggestion, error) {
+	ctx, span := startSpan(ctx, "MovieModel.Suggest")
+	defer span.End()
+
+	query := `
+		SELECT id, title
+		FROM movies
+		WHERE lower(title) LIKE $1 OR $2 <% title
+		ORDER BY lower(title) LIKE $1 DESC, word_similarity($2, title) DESC, title ASC, id ASC
+		LIMIT $3`
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+
+	rows, err := m.DB.QueryContext(ctx, query, pattern, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []Suggestion{}
+
+	for rows.Next() {
+		var suggestion Suggestion
+
+		err := rows.Scan(&suggestion.ID, &suggestion.Title)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, suggestion)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+// movieCursor returns the signed cursor for paging from movie in the current
+// sort order.
+func (f Filters) movieCursor(movie *Movie, before bool) string {
+	var value string
+
+	switch f.sortColumn() {
+	case "id":
+		value = strconv.FormatInt(movie.ID, 10)
+	case "title":
+		value = movie.Title
+	case "year":
+		value = strconv.FormatInt(int64(movie.Year), 10)
+	case "runtime":
+		value = strconv.FormatInt(int64(movie.Runtime), 10)
+	case "relevance":
+		value = strconv.FormatFloat(float64(movie.Relevance), 'g', -1, 32)
+	default:
+		panic("no cursor value for sort parameter: " + f.Sort)
+	}
+
+	return Cursor{Sort: f.Sort, Scope: f.CursorScope, Value: value, ID: movie.ID, Before: before}.Encode(f.CursorKey)
+}
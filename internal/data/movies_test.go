@@ -41,3 +41,58 @@ func TestValidateMovie(t *testing.T) {
 		movie.Year = 2024 // reset
 	})
 }
+
+func TestValidateMovieCriteria(t *testing.T) {
+	tests := []struct {
+		name     string
+		criteria MovieCriteria
+		wantErr  string
+	}{
+		{"Empty", MovieCriteria{}, ""},
+		{"All filters", MovieCriteria{YearMin: 1990, YearMax: 2000, RuntimeMin: 90, RuntimeMax: 120, GenresAll: []string{"drama"}, GenresAny: []string{"crime", "thriller"}, ExcludeGenres: []string{"comedy"}}, ""},
+		{"Year before cinema", MovieCriteria{YearMin: 1800}, "year_min"},
+		{"Year in the future", MovieCriteria{YearMax: 3000}, "year_max"},
+		{"Inverted year range", MovieCriteria{YearMin: 2000, YearMax: 1990}, "year_max"},
+		{"Negative runtime", MovieCriteria{RuntimeMin: -1}, "runtime_min"},
+		{"Inverted runtime range", MovieCriteria{RuntimeMin: 120, RuntimeMax: 90}, "runtime_max"},
+		{"Empty genre", MovieCriteria{GenresAny: []string{"drama", ""}}, "genres_any"},
+		{"Too many genres", MovieCriteria{GenresAll: make([]string, 21)}, "genres_all"},
+		{"Excluded required genre", MovieCriteria{GenresAll: []string{"drama"}, ExcludeGenres: []string{"drama"}}, "exclude_genres"},
+		{"Excluded optional genre", MovieCriteria{GenresAny: []string{"drama"}, ExcludeGenres: []string{"drama"}}, "exclude_genres"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateMovieCriteria(v, tt.criteria)
+
+			if tt.wantErr == "" && !v.Valid() {
+				t.Errorf("expected valid criteria, got errors: %v", v.Errors)
+			}
+			if _, ok := v.Errors[tt.wantErr]; tt.wantErr != "" && !ok {
+				t.Errorf("expected an error for %q, got %v", tt.wantErr, v.Errors)
+			}
+		})
+	}
+}
+
+func TestPrefixTSQuery(t *testing.T) {
+	tests := []struct {
+		search string
+		want   string
+	}{
+		{"star wa", "star:* & wa:*"},
+		{"  Amélie ", "Amélie:*"},
+		{"WALL·E", "WALL:* & E:*"},
+		{"2001: a space", "2001:* & a:* & space:*"},
+		{"star & !wars | (x):*", "star:* & wars:* & x:*"},
+		{"':*", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := prefixTSQuery(tt.search); got != tt.want {
+			t.Errorf("prefixTSQuery(%q): expected %q, got %q", tt.search, tt.want, got)
+		}
+	}
+}
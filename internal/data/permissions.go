@@ -3,10 +3,12 @@ package data
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"slices"
 	"time"
 
 	"github.com/lib/pq"
+	"github.com/recchia/greenlight/internal/validator"
 )
 
 type Permissions []string
@@ -15,18 +17,38 @@ func (p Permissions) Has(permission string) bool {
 	return slices.Contains(p, permission)
 }
 
+func ValidatePermissionCodes(v *validator.Validator, codes []string, known Permissions) {
+	v.Check(len(codes) > 0, "permissions", "must contain at least one permission")
+	v.Check(validator.Unique(codes), "permissions", "must not contain duplicate values")
+
+	for _, code := range codes {
+		v.Check(known.Has(code), "permissions", fmt.Sprintf("unknown permission %q", code))
+	}
+}
+
 type PermissionModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+// GetAllForUser returns the effective permissions of a user, combining the
+// codes granted directly with those bundled in the user's roles.
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	ctx, span := startSpan(ctx, "PermissionModel.GetAllForUser")
+	defer span.End()
+
 	query := `SELECT permissions.code
 		FROM permissions
 		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-		INNER JOIN users ON users_permissions.user_id = users.id
-		WHERE users.id = $1`
+		WHERE users_permissions.user_id = $1
+		UNION
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		WHERE users_roles.user_id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
@@ -58,13 +80,70 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	return permissions, nil
 }
 
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
-	query := `INSERT INTO users_permissions SELECT $1, permissions.id FROM permissions WHERE code = ANY($2)`
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	ctx, span := startSpan(ctx, "PermissionModel.AddForUser")
+	defer span.End()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	query := `INSERT INTO users_permissions SELECT $1, permissions.id FROM permissions WHERE code = ANY($2) ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 
 	return err
 }
+
+func (m PermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	ctx, span := startSpan(ctx, "PermissionModel.RemoveForUser")
+	defer span.End()
+
+	query := `DELETE FROM users_permissions
+		USING permissions
+		WHERE users_permissions.permission_id = permissions.id
+		AND users_permissions.user_id = $1
+		AND permissions.code = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+
+	return err
+}
+
+func (m PermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	ctx, span := startSpan(ctx, "PermissionModel.GetAll")
+	defer span.End()
+
+	query := `SELECT code FROM permissions ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var permission string
+
+		err = rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
@@ -0,0 +1,139 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/recchia/greenlight/internal/validator"
+)
+
+type Role struct {
+	Code        string      `json:"code"`
+	Permissions Permissions `json:"permissions"`
+}
+
+func ValidateRoleCodes(v *validator.Validator, codes []string, known []*Role) {
+	v.Check(len(codes) > 0, "roles", "must contain at least one role")
+	v.Check(validator.Unique(codes), "roles", "must not contain duplicate values")
+
+	for _, code := range codes {
+		found := false
+		for _, role := range known {
+			if role.Code == code {
+				found = true
+				break
+			}
+		}
+
+		v.Check(found, "roles", fmt.Sprintf("unknown role %q", code))
+	}
+}
+
+type RoleModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (m RoleModel) GetAll(ctx context.Context) ([]*Role, error) {
+	ctx, span := startSpan(ctx, "RoleModel.GetAll")
+	defer span.End()
+
+	query := `SELECT roles.code, COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+		FROM roles
+		LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+		LEFT JOIN permissions ON roles_permissions.permission_id = permissions.id
+		GROUP BY roles.code
+		ORDER BY roles.code`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRoles(rows)
+}
+
+func (m RoleModel) GetAllForUser(ctx context.Context, userID int64) ([]*Role, error) {
+	ctx, span := startSpan(ctx, "RoleModel.GetAllForUser")
+	defer span.End()
+
+	query := `SELECT roles.code, COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+		FROM roles
+		INNER JOIN users_roles ON users_roles.role_id = roles.id
+		LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+		LEFT JOIN permissions ON roles_permissions.permission_id = permissions.id
+		WHERE users_roles.user_id = $1
+		GROUP BY roles.code
+		ORDER BY roles.code`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRoles(rows)
+}
+
+func (m RoleModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	ctx, span := startSpan(ctx, "RoleModel.AddForUser")
+	defer span.End()
+
+	query := `INSERT INTO users_roles SELECT $1, roles.id FROM roles WHERE code = ANY($2) ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+
+	return err
+}
+
+func (m RoleModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	ctx, span := startSpan(ctx, "RoleModel.RemoveForUser")
+	defer span.End()
+
+	query := `DELETE FROM users_roles
+		USING roles
+		WHERE users_roles.role_id = roles.id
+		AND users_roles.user_id = $1
+		AND roles.code = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+
+	return err
+}
+
+func scanRoles(rows *sql.Rows) ([]*Role, error) {
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(&role.Code, pq.Array(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
@@ -0,0 +1,35 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/recchia/greenlight/internal/validator"
+)
+
+func TestValidateRoleCodes(t *testing.T) {
+	known := []*Role{{Code: "viewer"}, {Code: "editor"}}
+
+	t.Run("Known roles", func(t *testing.T) {
+		v := validator.New()
+		ValidateRoleCodes(v, []string{"viewer", "editor"}, known)
+		if !v.Valid() {
+			t.Errorf("expected valid roles, got errors: %v", v.Errors)
+		}
+	})
+
+	t.Run("Unknown role", func(t *testing.T) {
+		v := validator.New()
+		ValidateRoleCodes(v, []string{"owner"}, known)
+		if v.Valid() {
+			t.Error("expected invalid roles due to unknown code")
+		}
+	})
+
+	t.Run("Duplicates", func(t *testing.T) {
+		v := validator.New()
+		ValidateRoleCodes(v, []string{"viewer", "viewer"}, known)
+		if v.Valid() {
+			t.Error("expected invalid roles due to duplicate codes")
+		}
+	})
+}
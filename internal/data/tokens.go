@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/recchia/greenlight/internal/validator"
@@ -13,6 +14,10 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopeEmailChange    = "email-change"
+	ScopeTwoFactor      = "two-factor"
 )
 
 type Token struct {
@@ -21,6 +26,8 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	Family    string    `json:"-"`
+	Used      bool      `json:"-"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) *Token {
@@ -43,20 +50,40 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func (m TokenModel) New(userId int64, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(ctx context.Context, userId int64, ttl time.Duration, scope string) (*Token, error) {
+	ctx, span := startSpan(ctx, "TokenModel.New")
+	defer span.End()
+
 	token := generateToken(userId, ttl, scope)
-	err := m.Insert(token)
+	err := m.Insert(ctx, token)
 
 	return token, err
 }
 
-func (m TokenModel) Insert(token *Token) error {
-	query := `INSERT INTO tokens (hash, user_id, expiry, scope) VALUES ($1, $2, $3, $4)`
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// NewInFamily creates a token linked to other tokens issued from the same
+// login, so that the whole family can be revoked at once.
+func (m TokenModel) NewInFamily(ctx context.Context, userId int64, ttl time.Duration, scope, family string) (*Token, error) {
+	ctx, span := startSpan(ctx, "TokenModel.NewInFamily")
+	defer span.End()
+
+	token := generateToken(userId, ttl, scope)
+	token.Family = family
+	err := m.Insert(ctx, token)
+
+	return token, err
+}
+
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
+	ctx, span := startSpan(ctx, "TokenModel.Insert")
+	defer span.End()
+
+	query := `INSERT INTO tokens (hash, user_id, expiry, scope, family) VALUES ($1, $2, $3, $4, NULLIF($5, ''))`
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.Family}
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, args...)
@@ -64,12 +91,165 @@ func (m TokenModel) Insert(token *Token) error {
 	return err
 }
 
-func (m TokenModel) DeleteAllForUser(userId int64, scope string) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, userId int64, scope string) error {
+	ctx, span := startSpan(ctx, "TokenModel.DeleteAllForUser")
+	defer span.End()
+
 	query := `DELETE FROM tokens WHERE user_id = $1 AND scope = $2`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userId, scope)
 
 	return err
 }
+
+func (m TokenModel) Delete(ctx context.Context, tokenScope, tokenPlaintext string) error {
+	ctx, span := startSpan(ctx, "TokenModel.Delete")
+	defer span.End()
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `DELETE FROM tokens WHERE hash = $1 AND scope = $2`
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, tokenHash[:], tokenScope)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m TokenModel) GetByPlaintext(ctx context.Context, tokenScope, tokenPlaintext string) (*Token, error) {
+	ctx, span := startSpan(ctx, "TokenModel.GetByPlaintext")
+	defer span.End()
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `SELECT hash, user_id, expiry, scope, COALESCE(family, ''), used
+		FROM tokens
+		WHERE hash = $1
+		AND scope = $2
+		AND expiry > $3`
+
+	args := []any{tokenHash[:], tokenScope, time.Now()}
+
+	var token Token
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&token.Hash,
+		&token.UserID,
+		&token.Expiry,
+		&token.Scope,
+		&token.Family,
+		&token.Used,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	token.Plaintext = tokenPlaintext
+
+	return &token, nil
+}
+
+// MarkUsed flags a token as consumed. It returns ErrEditConflict if the token
+// had already been used, which happens when the same token is presented twice
+// concurrently.
+func (m TokenModel) MarkUsed(ctx context.Context, token *Token) error {
+	ctx, span := startSpan(ctx, "TokenModel.MarkUsed")
+	defer span.End()
+
+	query := `UPDATE tokens SET used = true WHERE hash = $1 AND used = false`
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, token.Hash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	token.Used = true
+
+	return nil
+}
+
+func (m TokenModel) DeleteFamily(ctx context.Context, userId int64, family string) error {
+	ctx, span := startSpan(ctx, "TokenModel.DeleteFamily")
+	defer span.End()
+
+	query := `DELETE FROM tokens WHERE user_id = $1 AND family = $2`
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userId, family)
+
+	return err
+}
+
+func (m TokenModel) GetAllForUser(ctx context.Context, userId int64) ([]*Token, error) {
+	ctx, span := startSpan(ctx, "TokenModel.GetAllForUser")
+	defer span.End()
+
+	query := `SELECT user_id, expiry, scope, COALESCE(family, ''), used
+		FROM tokens
+		WHERE user_id = $1
+		ORDER BY expiry`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tokens := []*Token{}
+
+	for rows.Next() {
+		var token Token
+
+		err := rows.Scan(&token.UserID, &token.Expiry, &token.Scope, &token.Family, &token.Used)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,259 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/recchia/greenlight/internal/validator"
+)
+
+const recoveryCodeCount = 10
+
+// TwoFactor holds a user's TOTP secret. It only protects logins once it has
+// been confirmed with a code from the user's authenticator app.
+type TwoFactor struct {
+	UserID       int64
+	Secret       string
+	CreatedAt    time.Time
+	Enabled      bool
+	LastUsedStep int64
+}
+
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6, "code", "must be 6 digits long")
+}
+
+// GenerateRecoveryCodes returns a set of single use codes that can stand in
+// for a TOTP code, along with the hashes to store for them.
+func GenerateRecoveryCodes() ([]string, [][]byte) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+
+	for i := range codes {
+		text := strings.ToLower(rand.Text())
+		codes[i] = text[:5] + "-" + text[5:10]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes
+}
+
+func hashRecoveryCode(code string) []byte {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hash[:]
+}
+
+type TwoFactorModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (m TwoFactorModel) Get(ctx context.Context, userID int64) (*TwoFactor, error) {
+	ctx, span := startSpan(ctx, "TwoFactorModel.Get")
+	defer span.End()
+
+	query := `SELECT user_id, secret, created_at, confirmed_at IS NOT NULL, last_used_step
+		FROM users_two_factor
+		WHERE user_id = $1`
+
+	var tf TwoFactor
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&tf.UserID,
+		&tf.Secret,
+		&tf.CreatedAt,
+		&tf.Enabled,
+		&tf.LastUsedStep,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &tf, nil
+}
+
+// Enrol stores a new, unconfirmed secret for the user, replacing any earlier
+// enrolment that was never confirmed. It returns ErrEditConflict if two-factor
+// authentication is already enabled.
+func (m TwoFactorModel) Enrol(ctx context.Context, tf *TwoFactor) error {
+	ctx, span := startSpan(ctx, "TwoFactorModel.Enrol")
+	defer span.End()
+
+	query := `INSERT INTO users_two_factor (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0
+		WHERE users_two_factor.confirmed_at IS NULL
+		RETURNING created_at, last_used_step`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tf.UserID, tf.Secret).Scan(&tf.CreatedAt, &tf.LastUsedStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Confirm enables two-factor authentication, records the step of the code
+// used to confirm it and replaces the user's recovery codes, all in one
+// transaction.
+func (m TwoFactorModel) Confirm(ctx context.Context, tf *TwoFactor, step int64, recoveryCodeHashes [][]byte) error {
+	ctx, span := startSpan(ctx, "TwoFactorModel.Confirm")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE users_two_factor SET confirmed_at = NOW(), last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL`
+
+	result, err := tx.ExecContext(ctx, query, tf.UserID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, tf.UserID)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (hash, user_id) VALUES ($1, $2)`, hash, tf.UserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	tf.Enabled = true
+	tf.LastUsedStep = step
+
+	return nil
+}
+
+// MarkStepUsed records that a code for step has been accepted. It returns
+// ErrEditConflict if a code for the same or a later step was already used,
+// so that intercepted codes can't be replayed.
+func (m TwoFactorModel) MarkStepUsed(ctx context.Context, tf *TwoFactor, step int64) error {
+	ctx, span := startSpan(ctx, "TwoFactorModel.MarkStepUsed")
+	defer span.End()
+
+	query := `UPDATE users_two_factor SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, tf.UserID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	tf.LastUsedStep = step
+
+	return nil
+}
+
+// UseRecoveryCode consumes one of the user's recovery codes. It returns
+// ErrRecordNotFound if the code doesn't exist or has already been used.
+func (m TwoFactorModel) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	ctx, span := startSpan(ctx, "TwoFactorModel.UseRecoveryCode")
+	defer span.End()
+
+	query := `UPDATE recovery_codes SET used_at = NOW() WHERE hash = $1 AND user_id = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hashRecoveryCode(code), userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete disables two-factor authentication for the user and removes their
+// recovery codes.
+func (m TwoFactorModel) Delete(ctx context.Context, userID int64) error {
+	ctx, span := startSpan(ctx, "TwoFactorModel.Delete")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_two_factor WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -24,13 +24,14 @@ type password struct {
 var AnonymousUser = &User{}
 
 type User struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Name         string    `json:"name"`
+	Email        string    `json:"email"`
+	PendingEmail string    `json:"pending_email,omitzero"`
+	Password     password  `json:"-"`
+	Activated    bool      `json:"activated"`
+	Version      int       `json:"-"`
 }
 
 func (u *User) IsAnonymous() bool {
@@ -91,17 +92,21 @@ func ValidateUser(v *validator.Validator, user *User) {
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
+	ctx, span := startSpan(ctx, "UserModel.Insert")
+	defer span.End()
+
 	query := `
 		INSERT INTO users (name, email, password_hash, activated) 
 		VALUES ($1, $2, $3, $4) 
 		RETURNING id, created_at, version`
 
 	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -121,14 +126,59 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
+	ctx, span := startSpan(ctx, "UserModel.Get")
+	defer span.End()
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated, version
+		SELECT id, created_at, name, email, COALESCE(pending_email, ''), password_hash, activated, version
 		FROM users
-		WHERE email = $1`
+		WHERE id = $1
+		AND deleted_at IS NULL`
 
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.PendingEmail,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, span := startSpan(ctx, "UserModel.GetByEmail")
+	defer span.End()
+
+	query := `
+		SELECT id, created_at, name, email, COALESCE(pending_email, ''), password_hash, activated, version
+		FROM users
+		WHERE email = $1
+		AND deleted_at IS NULL`
+
+	var user User
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
@@ -136,6 +186,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
+		&user.PendingEmail,
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
@@ -153,30 +204,34 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
+	ctx, span := startSpan(ctx, "UserModel.Update")
+	defer span.End()
+
 	query := `
 		UPDATE users
-		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		SET name = $1, email = $2, pending_email = NULLIF($3, ''), password_hash = $4, activated = $5, version = version + 1
+		WHERE id = $6 AND version = $7 AND deleted_at IS NULL
 		RETURNING version`
 
 	args := []any{
 		user.Name,
 		user.Email,
+		user.PendingEmail,
 		user.Password.hash,
 		user.Activated,
 		user.ID,
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case strings.Contains(err.Error(), "users_email_key"):
 			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
@@ -188,21 +243,65 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+// GetForIdentity returns the user linked to the external identity.
+func (m UserModel) GetForIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	ctx, span := startSpan(ctx, "UserModel.GetForIdentity")
+	defer span.End()
+
+	query := `SELECT users.id, users.created_at, users.name, users.email, COALESCE(users.pending_email, ''), users.password_hash, users.activated, users.version
+		FROM users
+		INNER JOIN users_identities
+		ON users.id = users_identities.user_id
+		WHERE users_identities.issuer = $1
+		AND users_identities.subject = $2
+		AND users.deleted_at IS NULL`
+
+	var user User
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.PendingEmail,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	ctx, span := startSpan(ctx, "UserModel.GetForToken")
+	defer span.End()
+
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
-	query := `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	query := `SELECT users.id, users.created_at, users.name, users.email, COALESCE(users.pending_email, ''), users.password_hash, users.activated, users.version
 		FROM users
 		INNER JOIN tokens
 		ON users.id = tokens.user_id
 		WHERE tokens.hash = $1
 		AND tokens.scope = $2
-		AND tokens.expiry > $3`
+		AND tokens.expiry > $3
+		AND users.deleted_at IS NULL`
 
 	args := []any{tokenHash[:], tokenScope, time.Now()}
 
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -210,6 +309,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
+		&user.PendingEmail,
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
@@ -226,3 +326,91 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	return &user, nil
 }
+
+// GetTokenVersion returns the token version a JWT issued to the user must
+// carry to be accepted. It returns ErrRecordNotFound for deleted users.
+func (m UserModel) GetTokenVersion(ctx context.Context, userID int64) (int, error) {
+	ctx, span := startSpan(ctx, "UserModel.GetTokenVersion")
+	defer span.End()
+
+	query := `SELECT token_version FROM users WHERE id = $1 AND deleted_at IS NULL`
+
+	var version int
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return version, nil
+}
+
+// IncrementTokenVersion invalidates every JWT issued to the user so far.
+func (m UserModel) IncrementTokenVersion(ctx context.Context, userID int64) error {
+	ctx, span := startSpan(ctx, "UserModel.IncrementTokenVersion")
+	defer span.End()
+
+	query := `UPDATE users SET token_version = token_version + 1 WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+
+	return err
+}
+
+// SoftDelete marks a user as deleted. The account can no longer be used, but
+// the row is kept until DeleteExpired removes it after the grace period.
+func (m UserModel) SoftDelete(ctx context.Context, user *User) error {
+	ctx, span := startSpan(ctx, "UserModel.SoftDelete")
+	defer span.End()
+
+	query := `
+		UPDATE users
+		SET deleted_at = NOW(), version = version + 1
+		WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteExpired permanently removes users soft deleted before the given time,
+// returning the number of users removed. Their tokens and permissions are
+// removed by the ON DELETE CASCADE foreign keys.
+func (m UserModel) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := startSpan(ctx, "UserModel.DeleteExpired")
+	defer span.End()
+
+	query := `DELETE FROM users WHERE deleted_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
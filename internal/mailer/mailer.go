@@ -2,11 +2,18 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"fmt"
+	"net"
 
 	"time"
 
 	"github.com/wneessen/go-mail"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	th "html/template"
 	tt "text/template"
@@ -15,9 +22,12 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
+var tracer = otel.Tracer("github.com/recchia/greenlight/internal/mailer")
+
 type Mailer struct {
 	client *mail.Client
 	sender string
+	addr   string
 }
 
 func New(host string, port int, username, password, sender string) (*Mailer, error) {
@@ -35,12 +45,36 @@ func New(host string, port int, username, password, sender string) (*Mailer, err
 		return nil, err
 	}
 
-	mailer := &Mailer{client, sender}
+	mailer := &Mailer{client, sender, net.JoinHostPort(host, fmt.Sprint(port))}
 
 	return mailer, nil
 }
 
-func (m *Mailer) Send(recipient string, templateFile string, data any) error {
+// Ping checks that the SMTP server accepts connections, without starting an
+// SMTP session.
+func (m *Mailer) Ping(ctx context.Context) error {
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// Send renders the template with data and sends it to recipient, recording
+// a span for the attempt.
+func (m *Mailer) Send(ctx context.Context, recipient string, templateFile string, data any) (err error) {
+	ctx, span := tracer.Start(ctx, "Mailer.Send", trace.WithAttributes(attribute.String("mail.template", templateFile)))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	textTmpl, err := tt.New("").ParseFS(templateFS, "templates/"+templateFile)
 
 	if err != nil {
@@ -92,5 +126,5 @@ func (m *Mailer) Send(recipient string, templateFile string, data any) error {
 	msg.SetBodyString(mail.TypeTextPlain, plainBody.String())
 	msg.AddAlternativeString(mail.TypeTextHTML, htmlBody.String())
 
-	return m.client.DialAndSend(msg)
+	return m.client.DialAndSendWithContext(ctx, msg)
 }
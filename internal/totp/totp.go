@@ -0,0 +1,108 @@
+// Package totp implements the time-based one-time passwords described in
+// RFC 6238, using the defaults understood by common authenticator apps: HMAC-
+// SHA1, six digits and a 30 second step.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30 * time.Second
+
+	// skew is the number of steps either side of the current one for which a
+	// code is still accepted, to allow for clock drift.
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random 160-bit secret, base32 encoded.
+func GenerateSecret() string {
+	secret := make([]byte, 20)
+	rand.Read(secret)
+
+	return encoding.EncodeToString(secret)
+}
+
+// URI returns the otpauth:// URI used to enrol the secret in an
+// authenticator app, usually by rendering it as a QR code.
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Step returns the time step t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the code for secret at time t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return hotp(key, uint64(Step(t)), Digits), nil
+}
+
+// Validate reports whether code is valid for secret at time t and, if so,
+// returns the step it was generated for. Callers should reject codes whose
+// step is not later than the last one accepted, so that a code can't be
+// replayed.
+func Validate(secret, code string, t time.Time) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil || len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+
+	for step := current - skew; step <= current+skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, uint64(step), Digits)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	return encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+}
+
+// hotp implements the HOTP algorithm from RFC 4226.
+func hotp(key []byte, counter uint64, digits int) string {
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range digits {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
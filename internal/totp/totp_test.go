@@ -0,0 +1,79 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHOTP(t *testing.T) {
+	// Test values from RFC 4226, appendix D.
+	key := []byte("12345678901234567890")
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	for counter, code := range want {
+		if got := hotp(key, uint64(counter), 6); got != code {
+			t.Errorf("counter %d: expected %s, got %s", counter, code, got)
+		}
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// Test values from RFC 6238, appendix B (SHA1 only).
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		step := Step(time.Unix(tt.unix, 0))
+		if got := hotp(key, uint64(step), 8); got != tt.code {
+			t.Errorf("time %d: expected %s, got %s", tt.unix, tt.code, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := GenerateSecret()
+	now := time.Now()
+
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if step, ok := Validate(secret, code, now); !ok || step != Step(now) {
+		t.Errorf("expected current code to be valid for step %d, got %d (%v)", Step(now), step, ok)
+	}
+
+	if _, ok := Validate(secret, code, now.Add(Period)); !ok {
+		t.Error("expected code from the previous step to be accepted")
+	}
+
+	if _, ok := Validate(secret, code, now.Add(3*Period)); ok {
+		t.Error("expected stale code to be rejected")
+	}
+
+	if _, ok := Validate(secret, "abc", now); ok {
+		t.Error("expected malformed code to be rejected")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Greenlight", "alice@example.com", "JBSWY3DPEHPK3PXP")
+
+	for _, part := range []string{"otpauth://totp/Greenlight:alice@example.com?", "secret=JBSWY3DPEHPK3PXP", "issuer=Greenlight"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("expected %q to contain %q", uri, part)
+		}
+	}
+}
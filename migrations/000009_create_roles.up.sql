@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS roles
+(
+    id   bigserial PRIMARY KEY,
+    code text UNIQUE NOT NULL
+);
+CREATE TABLE IF NOT EXISTS roles_permissions
+(
+    role_id       bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+CREATE TABLE IF NOT EXISTS users_roles
+(
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+-- Seed the default roles and the permission codes they bundle.
+INSERT INTO roles (code)
+VALUES ('viewer'),
+       ('editor'),
+       ('admin');
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles,
+     permissions
+WHERE (roles.code = 'viewer' AND permissions.code = 'movies:read')
+   OR (roles.code = 'editor' AND permissions.code IN ('movies:read', 'movies:write'))
+   OR roles.code = 'admin';
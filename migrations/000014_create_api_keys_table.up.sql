@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id           bigserial PRIMARY KEY,
+    user_id      bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    name         text                        NOT NULL,
+    hash         bytea UNIQUE                NOT NULL,
+    permissions  text[]                      NOT NULL,
+    created_at   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_used_at timestamp(0) with time zone,
+    version      integer                     NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
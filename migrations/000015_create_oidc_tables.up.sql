@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS users_identities
+(
+    issuer     text                        NOT NULL,
+    subject    text                        NOT NULL,
+    user_id    bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (issuer, subject)
+);
+CREATE INDEX IF NOT EXISTS users_identities_user_id_idx ON users_identities (user_id);
+CREATE TABLE IF NOT EXISTS oidc_logins
+(
+    state_hash    bytea PRIMARY KEY,
+    code_verifier text                        NOT NULL,
+    nonce         text                        NOT NULL,
+    expiry        timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS movies_title_trgm_idx;
+DROP INDEX IF EXISTS movies_title_english_idx;
+
+DROP EXTENSION IF EXISTS pg_trgm;